@@ -0,0 +1,212 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+const (
+	defaultHMACHeader          = "X-Signature"
+	defaultHMACTimestampHeader = "X-Signature-Timestamp"
+)
+
+// authProvider authorizes the outgoing API requests. It is called before each attempt of a request
+// so that rotated credentials are picked up by retries as well.
+type authProvider interface {
+	authorize(req *http.Request) error
+}
+
+// newAuthProvider returns the provider of the only auth method configured in conf. It returns nil
+// if conf is nil.
+func newAuthProvider(conf *core.APIAuth, cl *http.Client) (authProvider, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	providers := make([]authProvider, 0, 1)
+	if conf.HeaderKey != "" {
+		providers = append(providers, &headerAuth{key: conf.HeaderKey, value: conf.HeaderValue})
+	}
+	if conf.Bearer != nil {
+		if (conf.Bearer.Token == "") == (conf.Bearer.TokenFile == "") {
+			return nil, errors.New("exactly one of token and token_file must be specified for bearer auth")
+		}
+		providers = append(providers, &bearerAuth{token: conf.Bearer.Token, tokenFile: conf.Bearer.TokenFile})
+	}
+	if conf.Basic != nil {
+		providers = append(providers, &basicAuth{username: conf.Basic.Username, password: conf.Basic.Password})
+	}
+	if conf.OAuth2 != nil {
+		params := url.Values{}
+		for k, v := range conf.OAuth2.EndpointParams {
+			params.Set(k, v)
+		}
+		cc := &clientcredentials.Config{
+			ClientID:       conf.OAuth2.ClientID,
+			ClientSecret:   conf.OAuth2.ClientSecret,
+			TokenURL:       conf.OAuth2.TokenURL,
+			Scopes:         conf.OAuth2.Scopes,
+			EndpointParams: params,
+		}
+		// Token requests go through the same transport, so they share the TLS configuration
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, cl)
+		providers = append(providers, &oauth2Auth{tokenSource: cc.TokenSource(ctx)})
+	}
+	if conf.HMAC != nil {
+		if conf.HMAC.Secret == "" {
+			return nil, errors.New("secret must be specified for HMAC auth")
+		}
+		a := &hmacAuth{
+			secret:          []byte(conf.HMAC.Secret),
+			header:          conf.HMAC.Header,
+			timestampHeader: conf.HMAC.TimestampHeader,
+			now:             time.Now,
+		}
+		if a.header == "" {
+			a.header = defaultHMACHeader
+		}
+		if a.timestampHeader == "" {
+			a.timestampHeader = defaultHMACTimestampHeader
+		}
+		providers = append(providers, a)
+	}
+	if len(providers) != 1 {
+		return nil, fmt.Errorf("exactly one auth method must be configured, got %d", len(providers))
+	}
+	return providers[0], nil
+}
+
+type headerAuth struct {
+	key   string
+	value string
+}
+
+func (a *headerAuth) authorize(req *http.Request) error {
+	req.Header.Set(a.key, a.value)
+	return nil
+}
+
+// bearerAuth sets a bearer token which is either static or read from a file. The file is read
+// again whenever its modification time changes, e.g. a rotated projected service account token.
+type bearerAuth struct {
+	token     string
+	tokenFile string
+
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+func (a *bearerAuth) authorize(req *http.Request) error {
+	token, err := a.currentToken()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *bearerAuth) currentToken() (string, error) {
+	if a.tokenFile == "" {
+		return a.token, nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info, err := os.Stat(a.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("os.Stat: %v", err)
+	}
+	if a.token != "" && info.ModTime().Equal(a.modTime) {
+		return a.token, nil
+	}
+	b, err := os.ReadFile(a.tokenFile)
+	if err != nil {
+		return "", fmt.Errorf("os.ReadFile: %v", err)
+	}
+	a.token = strings.TrimSpace(string(b))
+	a.modTime = info.ModTime()
+	return a.token, nil
+}
+
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) authorize(req *http.Request) error {
+	req.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+// oauth2Auth sets the access token obtained through the OAuth2 client credentials flow. Tokens
+// are cached and refreshed once they expire.
+type oauth2Auth struct {
+	tokenSource oauth2.TokenSource
+}
+
+func (a *oauth2Auth) authorize(req *http.Request) error {
+	token, err := a.tokenSource.Token()
+	if err != nil {
+		return fmt.Errorf("failed to get OAuth2 token, err: %v", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
+
+// hmacAuth signs requests with HMAC-SHA256 over the canonical payload
+//
+//	<METHOD>\n<PATH>?<QUERY>\n<UNIX TIMESTAMP>\n<HEX SHA256 OF BODY>
+//
+// and sets the hex encoded signature and the timestamp as headers.
+type hmacAuth struct {
+	secret          []byte
+	header          string
+	timestampHeader string
+	now             func() time.Time
+}
+
+func (a *hmacAuth) authorize(req *http.Request) error {
+	body := []byte{}
+	if req.GetBody != nil {
+		rc, err := req.GetBody()
+		if err != nil {
+			return fmt.Errorf("failed to read request body, err: %v", err)
+		}
+		defer rc.Close()
+		if body, err = io.ReadAll(rc); err != nil {
+			return fmt.Errorf("failed to read request body, err: %v", err)
+		}
+	}
+	ts := strconv.FormatInt(a.now().Unix(), 10)
+	req.Header.Set(a.timestampHeader, ts)
+	req.Header.Set(a.header, hmacSignature(a.secret, req.Method, req.URL.RequestURI(), ts, body))
+	return nil
+}
+
+func hmacSignature(secret []byte, method, uri, ts string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	var payload bytes.Buffer
+	payload.WriteString(method + "\n")
+	payload.WriteString(uri + "\n")
+	payload.WriteString(ts + "\n")
+	payload.WriteString(hex.EncodeToString(bodyHash[:]))
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload.Bytes())
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+)
+
+func TestAuthProviders(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "my-client" || secret != "my-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"oauth2-token","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	tests := []struct {
+		desc  string
+		auth  *core.APIAuth
+		check func(r *http.Request) bool
+	}{
+		{
+			desc: "Static header",
+			auth: &core.APIAuth{HeaderKey: "X-API-Key", HeaderValue: "my-key"},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("X-API-Key") == "my-key"
+			},
+		},
+		{
+			desc: "Bearer token file",
+			auth: &core.APIAuth{Bearer: &core.BearerAuth{TokenFile: tokenFile}},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "Bearer file-token"
+			},
+		},
+		{
+			desc: "Basic",
+			auth: &core.APIAuth{Basic: &core.BasicAuth{Username: "user", Password: "pass"}},
+			check: func(r *http.Request) bool {
+				u, p, ok := r.BasicAuth()
+				return ok && u == "user" && p == "pass"
+			},
+		},
+		{
+			desc: "OAuth2 client credentials",
+			auth: &core.APIAuth{OAuth2: &core.OAuth2Auth{TokenURL: tokenSrv.URL, ClientID: "my-client", ClientSecret: "my-secret"}},
+			check: func(r *http.Request) bool {
+				return r.Header.Get("Authorization") == "Bearer oauth2-token"
+			},
+		},
+		{
+			desc: "HMAC",
+			auth: &core.APIAuth{HMAC: &core.HMACAuth{Secret: "my-secret"}},
+			check: func(r *http.Request) bool {
+				ts := r.Header.Get(defaultHMACTimestampHeader)
+				return ts != "" && r.Header.Get(defaultHMACHeader) == hmacSignature([]byte("my-secret"), r.Method, r.URL.RequestURI(), ts, []byte{})
+			},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if !tc.check(r) {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"tag":"v0.1.47","image":"my-image","url":"gcr.io/my-org/image:v0.1.47"}`))
+			}))
+			defer srv.Close()
+			cl, err := NewClient(&core.APIConfig{
+				BaseURL:           srv.URL,
+				LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest-version"},
+				TopLevelAuth:      tc.auth,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cl.GetLatestApplicableTag(context.Background(), "id", "my-image"); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestBearerAuthTokenRotation(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token-1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a := &bearerAuth{tokenFile: tokenFile}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := a.authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token-1" {
+		t.Fatalf("Wanted token-1, got %s instead", got)
+	}
+	if err := os.WriteFile(tokenFile, []byte("token-2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure the modification time differs even on file systems with coarse timestamps
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(tokenFile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("Authorization"); got != "Bearer token-2" {
+		t.Fatalf("Wanted token-2, got %s instead", got)
+	}
+}
+
+func TestHMACAuthSignsBody(t *testing.T) {
+	a := &hmacAuth{
+		secret:          []byte("my-secret"),
+		header:          defaultHMACHeader,
+		timestampHeader: defaultHMACTimestampHeader,
+		now:             func() time.Time { return time.Unix(1672567200, 0) },
+	}
+	req, err := http.NewRequest(http.MethodPut, "https://example.org/upload?size=4", strings.NewReader("logs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.authorize(req); err != nil {
+		t.Fatal(err)
+	}
+	want := hmacSignature([]byte("my-secret"), http.MethodPut, "/upload?size=4", "1672567200", []byte("logs"))
+	if got := req.Header.Get(defaultHMACHeader); got != want {
+		t.Fatalf("Wanted signature %s, got %s instead", want, got)
+	}
+	if got := req.Header.Get(defaultHMACTimestampHeader); got != "1672567200" {
+		t.Fatalf("Wanted timestamp 1672567200, got %s instead", got)
+	}
+}
+
+func TestNewAuthProviderErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		auth *core.APIAuth
+	}{
+		{
+			desc: "No method",
+			auth: &core.APIAuth{},
+		},
+		{
+			desc: "Multiple methods",
+			auth: &core.APIAuth{HeaderKey: "X-API-Key", HeaderValue: "my-key", Basic: &core.BasicAuth{Username: "user"}},
+		},
+		{
+			desc: "Bearer without token",
+			auth: &core.APIAuth{Bearer: &core.BearerAuth{}},
+		},
+		{
+			desc: "HMAC without secret",
+			auth: &core.APIAuth{HMAC: &core.HMACAuth{}},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := newAuthProvider(tc.auth, http.DefaultClient); err == nil {
+				t.Fatal("Wanted an error, got nil instead")
+			}
+		})
+	}
+}
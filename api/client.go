@@ -2,32 +2,82 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/edgedelta/updater/core"
 	"github.com/edgedelta/updater/core/compressors"
 	"github.com/edgedelta/updater/core/encoders"
 	"github.com/edgedelta/updater/log"
+	"github.com/edgedelta/updater/metrics"
+
+	zerolog "github.com/rs/zerolog/log"
+)
+
+const (
+	defaultTimeout        = time.Minute
+	defaultMaxAttempts    = 3
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = 30 * time.Second
+
+	// Operations of the requests in the metrics
+	opLatestTag             = "latest_tag"
+	opSignature             = "signature"
+	opReport                = "report"
+	opPresignedLogUploadURL = "presigned_log_upload_url"
+	opLogUpload             = "log_upload"
+	opMetadata              = "metadata"
+)
+
+var (
+	defaultRetryOnStatus = []int{
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	}
 )
 
 type Client struct {
-	cl   *http.Client
-	conf *core.APIConfig
+	cl    *http.Client
+	conf  *core.APIConfig
+	retry core.RetryConfig
+	auth  authProvider
 }
 
-func NewClient(conf *core.APIConfig) *Client {
+func NewClient(conf *core.APIConfig) (*Client, error) {
 	if conf == nil {
-		return nil
+		return nil, nil
+	}
+	timeout := conf.Timeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
 	}
-	cl := http.DefaultClient
-	return &Client{cl: cl, conf: conf}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.TLS != nil {
+		tlsConf, err := newTLSConfig(conf.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create TLS config, err: %v", err)
+		}
+		transport.TLSClientConfig = tlsConf
+	}
+	cl := &http.Client{Timeout: timeout, Transport: transport}
+	auth, err := newAuthProvider(conf.TopLevelAuth, cl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create auth provider, err: %v", err)
+	}
+	return &Client{cl: cl, conf: conf, retry: retryConfigWithDefaults(conf.Retry), auth: auth}, nil
 }
 
-func (c *Client) GetLatestApplicableTag(id, name string) (*core.LatestTagResponse, error) {
+func (c *Client) GetLatestApplicableTag(ctx context.Context, id, name string) (*core.LatestTagResponse, error) {
 	params := &core.ParamConf{
 		QueryParams: map[string]string{
 			"entity": name,
@@ -44,34 +94,87 @@ func (c *Client) GetLatestApplicableTag(id, name string) (*core.LatestTagRespons
 	if err != nil {
 		return nil, fmt.Errorf("failed to construct URL with params, err: %v", err)
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create HTTP request, err: %v", err)
 	}
-	if c.conf.TopLevelAuth != nil {
-		req.Header.Add(c.conf.TopLevelAuth.HeaderKey, c.conf.TopLevelAuth.HeaderValue)
+	data, err := c.do(opLatestTag, req)
+	if err != nil {
+		return nil, err
 	}
-	res, err := c.cl.Do(req)
+	var r core.LatestTagResponse
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("json.Unmarshall: %v", err)
+	}
+	return &r, nil
+}
+
+func (c *Client) GetSignature(ctx context.Context, name string, res *core.LatestTagResponse) (string, error) {
+	endpoint := c.conf.Signature.Endpoint
+	vars := map[string]string{
+		"entity": name,
+		"image":  res.URL,
+		"tag":    res.Tag,
+		"digest": res.Digest,
+	}
+	params := &core.ParamConf{QueryParams: make(map[string]string)}
+	for k, v := range vars {
+		if v != "" {
+			params.QueryParams[k] = v
+		}
+	}
+	if endpoint.Params != nil {
+		for k, v := range endpoint.Params.QueryParams {
+			params.QueryParams[k] = v
+		}
+	}
+	url, err := constructURLWithParams(c.conf.BaseURL+endpoint.Endpoint, params, vars)
 	if err != nil {
-		return nil, fmt.Errorf("failed to do HTTP request: %v", err)
+		return "", fmt.Errorf("failed to construct URL with params, err: %v", err)
 	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
+		return "", fmt.Errorf("failed to create HTTP request, err: %v", err)
 	}
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+	data, err := c.do(opSignature, req)
+	if err != nil {
+		return "", err
+	}
+	var r struct {
+		Signature string `json:"signature"`
 	}
-	var r core.LatestTagResponse
 	if err := json.Unmarshal(data, &r); err != nil {
-		return nil, fmt.Errorf("json.Unmarshall: %v", err)
+		return "", fmt.Errorf("json.Unmarshal: %v", err)
 	}
-	return &r, nil
+	return r.Signature, nil
+}
+
+func (c *Client) Report(ctx context.Context, report *core.UpdateReport) error {
+	endpoint := c.conf.ReportEndpoint
+	vars := map[string]string{
+		"entity":    report.Image,
+		"entity_id": report.EntityID,
+		"tag":       report.Tag,
+	}
+	url, err := constructURLWithParams(c.conf.BaseURL+endpoint.Endpoint, endpoint.Params, vars)
+	if err != nil {
+		return fmt.Errorf("failed to construct URL with params, err: %v", err)
+	}
+	body, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create HTTP request, err: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	_, err = c.do(opReport, req)
+	return err
 }
 
-func (c *Client) GetPresignedLogUploadURL(logSize int) (string, error) {
-	log.Debug("api.Client.GetPresignedLogUploadURL: Called with log size %d", logSize)
+func (c *Client) GetPresignedLogUploadURL(ctx context.Context, logSize int) (string, error) {
+	zerolog.Debug().Msgf("api.Client.GetPresignedLogUploadURL: Called with log size %d", logSize)
 	url, err := constructURLWithParams(
 		c.conf.BaseURL+c.conf.LogUpload.PresignedUploadURLEndpoint.Endpoint,
 		c.conf.LogUpload.PresignedUploadURLEndpoint.Params, map[string]string{
@@ -81,24 +184,13 @@ func (c *Client) GetPresignedLogUploadURL(logSize int) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to construct URL with params, err: %v", err)
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return "", fmt.Errorf("failed to create HTTP request, err: %v", err)
 	}
-	if c.conf.TopLevelAuth != nil {
-		req.Header.Add(c.conf.TopLevelAuth.HeaderKey, c.conf.TopLevelAuth.HeaderValue)
-	}
-	res, err := c.cl.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("failed to do HTTP request: %v", err)
-	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
+	data, err := c.do(opPresignedLogUploadURL, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return "", fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+		return "", err
 	}
 	var presignedURL string
 	if err := json.Unmarshal(data, &presignedURL); err != nil {
@@ -107,7 +199,7 @@ func (c *Client) GetPresignedLogUploadURL(logSize int) (string, error) {
 	return presignedURL, nil
 }
 
-func (c *Client) UploadLogs(lines []interface{}) error {
+func (c *Client) UploadLogs(ctx context.Context, lines []interface{}) error {
 	wr := new(bytes.Buffer)
 	comp, err := compressors.New(wr, c.conf.LogUpload.Compression)
 	if err != nil {
@@ -129,7 +221,7 @@ func (c *Client) UploadLogs(lines []interface{}) error {
 	if err := comp.Close(); err != nil {
 		return fmt.Errorf("compressors.Compressor.Close: %v", err)
 	}
-	presignedURL, err := c.GetPresignedLogUploadURL(wr.Len())
+	presignedURL, err := c.GetPresignedLogUploadURL(ctx, wr.Len())
 	if err != nil {
 		return fmt.Errorf("failed to get presigned upload URL: %v", err)
 	}
@@ -137,60 +229,179 @@ func (c *Client) UploadLogs(lines []interface{}) error {
 	if err != nil {
 		return fmt.Errorf("failed to construct URL with params, err: %v", err)
 	}
-	req, err := http.NewRequest(c.conf.LogUpload.Method, url, wr)
+	req, err := http.NewRequestWithContext(ctx, c.conf.LogUpload.Method, url, bytes.NewReader(wr.Bytes()))
 	if err != nil {
 		return fmt.Errorf("failed to create HTTP request, err: %v", err)
 	}
-	if c.conf.TopLevelAuth != nil {
-		req.Header.Add(c.conf.TopLevelAuth.HeaderKey, c.conf.TopLevelAuth.HeaderValue)
+	_, err = c.do(opLogUpload, req)
+	return err
+}
+
+func (c *Client) GetMetadata(ctx context.Context) (map[string]string, error) {
+	url, err := constructURLWithParams(
+		c.conf.BaseURL+c.conf.MetadataEndpoint.Endpoint,
+		c.conf.MetadataEndpoint.Params, nil,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("constructURLWithParams err: %v", err)
 	}
-	res, err := c.cl.Do(req)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return fmt.Errorf("failed to do HTTP request: %v", err)
+		return nil, fmt.Errorf("http.NewRequestWithContext: %v", err)
 	}
-	defer res.Body.Close()
-	data, err := io.ReadAll(res.Body)
+	data, err := c.do(opMetadata, req)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %v", err)
+		return nil, fmt.Errorf("api.Client.do: %v", err)
 	}
-	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+	var r map[string]string
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %v", err)
 	}
-	return nil
+	return r, nil
 }
 
-func (c *Client) GetMetadata() (map[string]string, error) {
-	url, err := constructURLWithParams(
-		c.conf.BaseURL+c.conf.MetadataEndpoint.Endpoint,
-		c.conf.MetadataEndpoint.Params, nil,
-	)
+func (c *Client) Ping(ctx context.Context) error {
+	url := fmt.Sprintf("%s%s", c.conf.BaseURL, c.conf.LatestTagEndpoint.Endpoint)
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("constructURLWithParams err: %v", err)
+		return fmt.Errorf("failed to create HTTP request, err: %v", err)
+	}
+	if c.auth != nil {
+		if err := c.auth.authorize(req); err != nil {
+			return fmt.Errorf("failed to authorize HTTP request: %v", err)
+		}
 	}
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	res, err := c.cl.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("http.NewRequest: %v", err)
+		return fmt.Errorf("failed to do HTTP request: %v", err)
 	}
-	if c.conf.TopLevelAuth != nil {
-		req.Header.Add(c.conf.TopLevelAuth.HeaderKey, c.conf.TopLevelAuth.HeaderValue)
+	res.Body.Close()
+	// Other client errors count as reachable, the endpoint might require parameters
+	if res.StatusCode >= http.StatusInternalServerError || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
 	}
+	return nil
+}
+
+func (c *Client) do(operation string, req *http.Request) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		data, retryable, retryAfter, err := c.doOnce(operation, req)
+		if err == nil {
+			return data, nil
+		}
+		if !retryable || attempt >= c.retry.MaxAttempts || req.Context().Err() != nil {
+			return nil, err
+		}
+		delay := retryAfter
+		if delay <= 0 {
+			delay = backoff(c.retry, attempt)
+		}
+		format, args := "api.Client: Attempt %d/%d of %s %s%s failed, retrying in %s, err: %v", []any{attempt, c.retry.MaxAttempts, req.Method, req.URL.Host, req.URL.Path, delay, err}
+		if operation == opPresignedLogUploadURL || operation == opLogUpload {
+			// These run on the log uploader's goroutine, logging through log would block on its channel
+			zerolog.Warn().Msgf(format, args...)
+		} else {
+			log.Warn(format, args...)
+		}
+		t := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			t.Stop()
+			return nil, err
+		case <-t.C:
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body, err: %v", err)
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+func (c *Client) doOnce(operation string, req *http.Request) ([]byte, bool, time.Duration, error) {
+	// Presigned upload URLs carry their own authorization, uploads only get the legacy static header
+	if _, static := c.auth.(*headerAuth); c.auth != nil && (operation != opLogUpload || static) {
+		if err := c.auth.authorize(req); err != nil {
+			return nil, true, 0, fmt.Errorf("failed to authorize HTTP request: %v", err)
+		}
+	}
+	start := time.Now()
 	res, err := c.cl.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("http.Client.Do: %v", err)
+		metrics.ObserveAPIRequest(operation, 0, time.Since(start))
+		return nil, true, 0, fmt.Errorf("failed to do HTTP request: %v", err)
 	}
 	defer res.Body.Close()
 	data, err := io.ReadAll(res.Body)
+	metrics.ObserveAPIRequest(operation, res.StatusCode, time.Since(start))
 	if err != nil {
-		return nil, fmt.Errorf("io.ReadAll: %v", err)
+		return nil, true, 0, fmt.Errorf("failed to read response body: %v", err)
 	}
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		return nil, fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+		err := fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+		return nil, c.retryableStatus(res.StatusCode), parseRetryAfter(res.Header.Get("Retry-After"), time.Now()), err
 	}
-	var r map[string]string
-	if err := json.Unmarshal(data, &r); err != nil {
-		return nil, fmt.Errorf("json.Unmarshal: %v", err)
+	return data, false, 0, nil
+}
+
+func (c *Client) retryableStatus(code int) bool {
+	for _, s := range c.retry.RetryOnStatus {
+		if s == code {
+			return true
+		}
 	}
-	return r, nil
+	return false
+}
+
+func retryConfigWithDefaults(conf *core.RetryConfig) core.RetryConfig {
+	r := core.RetryConfig{}
+	if conf != nil {
+		r = *conf
+	}
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultMaxAttempts
+	}
+	if r.InitialBackoff <= 0 {
+		r.InitialBackoff = defaultInitialBackoff
+	}
+	if r.MaxBackoff <= 0 {
+		r.MaxBackoff = defaultMaxBackoff
+	}
+	if len(r.RetryOnStatus) == 0 {
+		r.RetryOnStatus = defaultRetryOnStatus
+	}
+	return r
+}
+
+func backoff(conf core.RetryConfig, attempt int) time.Duration {
+	d := conf.InitialBackoff
+	for i := 1; i < attempt && d < conf.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > conf.MaxBackoff {
+		d = conf.MaxBackoff
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
 }
 
 func constructURLWithParams(base string, params *core.ParamConf, ctxVars map[string]string) (string, error) {
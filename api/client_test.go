@@ -1,9 +1,17 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/log"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -61,3 +69,355 @@ func TestConstructURLWithParams(t *testing.T) {
 		})
 	}
 }
+
+func TestGetLatestApplicableTagRetry(t *testing.T) {
+	tests := []struct {
+		desc         string
+		statuses     []int
+		maxAttempts  int
+		wantErr      bool
+		wantAttempts int
+	}{
+		{
+			desc:         "Succeeds after retryable failures",
+			statuses:     []int{http.StatusServiceUnavailable, http.StatusTooManyRequests, http.StatusOK},
+			maxAttempts:  3,
+			wantAttempts: 3,
+		},
+		{
+			desc:         "Fails after max attempts",
+			statuses:     []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusOK},
+			maxAttempts:  2,
+			wantErr:      true,
+			wantAttempts: 2,
+		},
+		{
+			desc:         "Does not retry non-retryable status",
+			statuses:     []int{http.StatusUnauthorized, http.StatusOK},
+			maxAttempts:  3,
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			attempts := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tc.statuses[attempts]
+				attempts++
+				if status != http.StatusOK {
+					w.Header().Set("Retry-After", "0")
+					w.WriteHeader(status)
+					return
+				}
+				w.Write([]byte(`{"tag":"v0.1.47","image":"my-image","url":"gcr.io/my-org/image:v0.1.47"}`))
+			}))
+			defer srv.Close()
+			cl, err := NewClient(&core.APIConfig{
+				BaseURL:           srv.URL,
+				LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest-version"},
+				Retry: &core.RetryConfig{
+					MaxAttempts:    tc.maxAttempts,
+					InitialBackoff: time.Millisecond,
+					MaxBackoff:     5 * time.Millisecond,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := cl.GetLatestApplicableTag(context.Background(), "id", "my-image")
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+			if attempts != tc.wantAttempts {
+				t.Fatalf("Wanted %d attempts, got %d instead", tc.wantAttempts, attempts)
+			}
+			if !tc.wantErr && res.Tag != "v0.1.47" {
+				t.Fatalf("Wanted tag v0.1.47, got %s instead", res.Tag)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{value: "", want: 0},
+		{value: "5", want: 5 * time.Second},
+		{value: "-1", want: 0},
+		{value: "Sun, 01 Jan 2023 10:00:30 GMT", want: 30 * time.Second},
+		{value: "Sun, 01 Jan 2023 09:59:00 GMT", want: 0},
+		{value: "soon", want: 0},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.value, func(t *testing.T) {
+			if got := parseRetryAfter(tc.value, now); got != tc.want {
+				t.Errorf("Wanted %s, got %s instead", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	conf := core.RetryConfig{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	for attempt, max := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		got := backoff(conf, attempt+1)
+		if got < max/2 || got > max {
+			t.Errorf("Wanted backoff of attempt %d in range [%s, %s], got %s instead", attempt+1, max/2, max, got)
+		}
+	}
+}
+
+func TestGetLatestApplicableTagCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	cl, err := NewClient(&core.APIConfig{
+		BaseURL:           srv.URL,
+		LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest-version"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := cl.GetLatestApplicableTag(ctx, "id", "my-image"); err == nil {
+		t.Fatal("Wanted an error, got nil instead")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Request is not cancelled in time, took %s", elapsed)
+	}
+}
+
+func TestGetSignature(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if r.URL.Path != "/signature" || q.Get("entity") != "my-image" || q.Get("image") != "gcr.io/my-org/image:v0.1.47" || q.Get("ref") != "my-image:v0.1.47" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"signature":"c2lnbmF0dXJl"}`))
+	}))
+	defer srv.Close()
+	cl, err := NewClient(&core.APIConfig{
+		BaseURL: srv.URL,
+		Signature: &core.SignatureConfig{
+			Endpoint: &core.EndpointConfig{
+				Endpoint: "/signature",
+				Params:   &core.ParamConf{QueryParams: map[string]string{"ref": `{{ index .Vars "entity" }}:{{ index .Vars "tag" }}`}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cl.GetSignature(context.Background(), "my-image", &core.LatestTagResponse{Tag: "v0.1.47", Image: "my-image", URL: "gcr.io/my-org/image:v0.1.47"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "c2lnbmF0dXJl" {
+		t.Errorf("Wanted signature c2lnbmF0dXJl, got %s instead", got)
+	}
+}
+
+func TestReport(t *testing.T) {
+	report := &core.UpdateReport{
+		EntityID: "111",
+		Image:    "my-image",
+		Tag:      "v0.1.47",
+		Paths: []core.PathReport{{
+			Path:                   "default:ds/agent:spec.template.spec.containers[0].image",
+			Old:                    "gcr.io/my-org/image:v0.1.46",
+			New:                    "gcr.io/my-org/image:v0.1.47",
+			Status:                 core.ChangeUpdate,
+			RolloutDurationSeconds: 12.5,
+		}},
+	}
+	var got core.UpdateReport
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/report" || r.URL.Query().Get("id") != "111" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+	cl, err := NewClient(&core.APIConfig{
+		BaseURL: srv.URL,
+		ReportEndpoint: &core.EndpointConfig{
+			Endpoint: "/report",
+			Params:   &core.ParamConf{QueryParams: map[string]string{"id": `{{ index .Vars "entity_id" }}`}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Report(context.Background(), report); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(*report, got); diff != "" {
+		t.Errorf("Report() body mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		desc    string
+		code    int
+		wantErr bool
+	}{
+		{desc: "OK", code: http.StatusOK},
+		{desc: "Missing parameters", code: http.StatusBadRequest},
+		{desc: "Not found", code: http.StatusNotFound},
+		{desc: "Unauthorized", code: http.StatusUnauthorized, wantErr: true},
+		{desc: "Forbidden", code: http.StatusForbidden, wantErr: true},
+		{desc: "Internal server error", code: http.StatusInternalServerError, wantErr: true},
+		{desc: "Unavailable", code: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth = r.Header.Get("Authorization")
+				w.WriteHeader(tc.code)
+			}))
+			defer srv.Close()
+			cl, err := NewClient(&core.APIConfig{
+				BaseURL:           srv.URL,
+				LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest"},
+				TopLevelAuth:      &core.APIAuth{Bearer: &core.BearerAuth{Token: "secret"}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cl.Ping(context.Background())
+			if tc.wantErr != (err != nil) {
+				t.Errorf("Wanted error: %v, got %v instead", tc.wantErr, err)
+			}
+			if auth != "Bearer secret" {
+				t.Errorf("Wanted the ping to be authorized, got Authorization %q instead", auth)
+			}
+		})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	cl, err := NewClient(&core.APIConfig{BaseURL: srv.URL, LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	if err := cl.Ping(context.Background()); err == nil {
+		t.Error("Wanted an error for an unreachable API, got nil instead")
+	}
+}
+
+func TestUploadLogsAuthorization(t *testing.T) {
+	tests := []struct {
+		desc           string
+		auth           *core.APIAuth
+		wantUploadAuth string
+	}{
+		{
+			desc: "Bearer",
+			auth: &core.APIAuth{Bearer: &core.BearerAuth{Token: "secret"}},
+		},
+		{
+			desc:           "Static header",
+			auth:           &core.APIAuth{HeaderKey: "Authorization", HeaderValue: "Bearer secret"},
+			wantUploadAuth: "Bearer secret",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			var uploadAuth, presignAuth string
+			var uploaded bool
+			var srv *httptest.Server
+			srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/presigned":
+					presignAuth = r.Header.Get("Authorization")
+					json.NewEncoder(w).Encode(srv.URL + "/upload?X-Amz-Signature=abc")
+				case "/upload":
+					uploadAuth, uploaded = r.Header.Get("Authorization"), true
+				}
+			}))
+			defer srv.Close()
+			cl, err := NewClient(&core.APIConfig{
+				BaseURL:      srv.URL,
+				TopLevelAuth: tc.auth,
+				LogUpload: &core.LogUploadConfig{
+					Enabled:                    true,
+					PresignedUploadURLEndpoint: core.EndpointConfig{Endpoint: "/presigned"},
+					Method:                     http.MethodPut,
+					Encoding:                   &core.EncodingConfig{Type: core.EncodingJSON},
+					Compression:                core.CompressionGzip,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cl.UploadLogs(context.Background(), []any{"line"}); err != nil {
+				t.Fatal(err)
+			}
+			if presignAuth != "Bearer secret" {
+				t.Errorf("Wanted the presigned URL request to be authorized, got Authorization %q instead", presignAuth)
+			}
+			if !uploaded || uploadAuth != tc.wantUploadAuth {
+				t.Errorf("Wanted the upload with Authorization %q, got %q instead", tc.wantUploadAuth, uploadAuth)
+			}
+		})
+	}
+}
+
+func TestUploadLogsRetriesAreNotLogged(t *testing.T) {
+	// The log uploader writes the logs into a channel it reads on the goroutine uploading them
+	logs := new(bytes.Buffer)
+	log.SetWriters(logs)
+	defer log.SetWriters(os.Stdout)
+
+	attempts := 0
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.URL.Path == "/presigned" {
+			json.NewEncoder(w).Encode(srv.URL + "/upload")
+		}
+	}))
+	defer srv.Close()
+	cl, err := NewClient(&core.APIConfig{
+		BaseURL: srv.URL,
+		Retry:   &core.RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond},
+		LogUpload: &core.LogUploadConfig{
+			Enabled:                    true,
+			PresignedUploadURLEndpoint: core.EndpointConfig{Endpoint: "/presigned"},
+			Method:                     http.MethodPut,
+			Encoding:                   &core.EncodingConfig{Type: core.EncodingJSON},
+			Compression:                core.CompressionGzip,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.UploadLogs(context.Background(), []any{"line"}); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 4 {
+		t.Errorf("Wanted 4 attempts, got %d instead", attempts)
+	}
+	if logs.Len() > 0 {
+		t.Errorf("Wanted no logs, got %s instead", logs)
+	}
+}
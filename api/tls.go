@@ -0,0 +1,82 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/edgedelta/updater/core"
+)
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+)
+
+// newTLSConfig builds the TLS configuration of the API client's transport. The CA bundle and the
+// client certificate can either be given as file paths or as PEM encoded contents.
+func newTLSConfig(conf *core.TLSConfig) (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		ServerName:         conf.ServerName,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+	if conf.MinVersion != "" {
+		v, ok := tlsVersions[conf.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", conf.MinVersion)
+		}
+		tlsConf.MinVersion = v
+	}
+	caPEM, err := pemContent(conf.CA, conf.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle, err: %v", err)
+	}
+	if caPEM != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no valid certificate is found in CA bundle")
+		}
+		tlsConf.RootCAs = pool
+	}
+	certPEM, err := pemContent(conf.Cert, conf.CertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client certificate, err: %v", err)
+	}
+	keyPEM, err := pemContent(conf.Key, conf.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read client key, err: %v", err)
+	}
+	if (certPEM == nil) != (keyPEM == nil) {
+		return nil, errors.New("client certificate and key must be specified together")
+	}
+	if certPEM != nil {
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			return nil, fmt.Errorf("tls.X509KeyPair: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConf, nil
+}
+
+func pemContent(content, path string) ([]byte, error) {
+	if content != "" && path != "" {
+		return nil, errors.New("only one of the content and the file path can be specified")
+	}
+	if content != "" {
+		return []byte(content), nil
+	}
+	if path != "" {
+		return os.ReadFile(path)
+	}
+	return nil, nil
+}
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+)
+
+func TestClientTLS(t *testing.T) {
+	caCert, caKey := generateCA(t)
+	clientCertPEM, clientKeyPEM := generateClientCert(t, caCert, caKey)
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"tag":"v0.1.47","image":"my-image","url":"gcr.io/my-org/image:v0.1.47"}`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: pool}
+	srv.StartTLS()
+	defer srv.Close()
+
+	serverCAPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, serverCAPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		desc    string
+		tls     *core.TLSConfig
+		wantErr bool
+	}{
+		{
+			desc:    "Unknown CA",
+			tls:     nil,
+			wantErr: true,
+		},
+		{
+			desc:    "Trusted CA without client certificate",
+			tls:     &core.TLSConfig{CAFile: caFile},
+			wantErr: true,
+		},
+		{
+			desc: "Trusted CA with client certificate",
+			tls: &core.TLSConfig{
+				CAFile:     caFile,
+				Cert:       string(clientCertPEM),
+				Key:        string(clientKeyPEM),
+				MinVersion: "1.2",
+			},
+		},
+		{
+			desc: "Insecure skip verify with client certificate",
+			tls: &core.TLSConfig{
+				Cert:               string(clientCertPEM),
+				Key:                string(clientKeyPEM),
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			cl, err := NewClient(&core.APIConfig{
+				BaseURL:           srv.URL,
+				LatestTagEndpoint: core.EndpointConfig{Endpoint: "/latest-version"},
+				Retry:             &core.RetryConfig{MaxAttempts: 1},
+				TLS:               tc.tls,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = cl.GetLatestApplicableTag(context.Background(), "id", "my-image")
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNewTLSConfigErrors(t *testing.T) {
+	tests := []struct {
+		desc string
+		conf *core.TLSConfig
+	}{
+		{
+			desc: "Unknown min version",
+			conf: &core.TLSConfig{MinVersion: "2.0"},
+		},
+		{
+			desc: "Invalid CA bundle",
+			conf: &core.TLSConfig{CA: "not a certificate"},
+		},
+		{
+			desc: "Missing CA file",
+			conf: &core.TLSConfig{CAFile: "/does/not/exist.pem"},
+		},
+		{
+			desc: "Certificate without key",
+			conf: &core.TLSConfig{Cert: "-----BEGIN CERTIFICATE-----"},
+		},
+		{
+			desc: "Both CA content and file",
+			conf: &core.TLSConfig{CA: "-----BEGIN CERTIFICATE-----", CAFile: "ca.pem"},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, err := newTLSConfig(tc.conf); err == nil {
+				t.Fatal("Wanted an error, got nil instead")
+			}
+		})
+	}
+}
+
+func generateCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func generateClientCert(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey) ([]byte, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "test-client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -4,22 +4,39 @@ import (
 	"context"
 	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"os/signal"
 	"runtime/debug"
+	"syscall"
 	"time"
 
 	"github.com/edgedelta/updater"
+	"github.com/edgedelta/updater/core"
 	"github.com/edgedelta/updater/log"
 	"github.com/edgedelta/updater/loguploader"
+	"github.com/edgedelta/updater/metrics"
+	"github.com/edgedelta/updater/scheduler"
 )
 
 var (
 	configPath  = flag.String("config", "", "Local config path")
+	dryRun      = flag.String("dry-run", string(core.DryRunNone), `Must be "none", "client", or "server". If not "none", the changes are printed instead of being applied, "server" also submits them to the K8s API server as dry run requests`)
+	output      = flag.String("output", outputTable, `Output format of the dry run changes, either "table" or "json"`)
+	interval    = flag.Duration("interval", 0, "If set, the updater keeps running and updates the entities every interval, starting right away")
+	schedule    = flag.String("schedule", "", `If set, the updater keeps running and updates the entities on the given cron schedule (e.g. "*/10 * * * *")`)
+	jitter      = flag.Duration("jitter", 0, "Maximum random delay added before each scheduled run in daemon mode")
+	metricsAddr = flag.String("metrics-addr", "", `If set in daemon mode, the Prometheus metrics and the health endpoints are served on this address (e.g. ":9090") at /metrics, /healthz and /readyz`)
+	liveness    = flag.Duration("liveness-threshold", time.Hour, "In daemon mode, /healthz fails if a run takes longer or the next run is overdue by more than this threshold")
+	metricsPush = flag.String("metrics-push-url", "", "If set, the Prometheus metrics of a one-shot run are pushed to the Pushgateway at this URL before exiting")
 	logUploader *loguploader.Uploader
+	// daemonFailed is set if the daemon stopped on its own rather than on a shutdown signal
+	daemonFailed bool
 )
 
 const (
 	gracefulShutdownPeriod = time.Minute
+	metricsPushTimeout     = 30 * time.Second
 )
 
 func main() {
@@ -28,7 +45,8 @@ func main() {
 			log.Error("Main goroutine panicked, err: %v, stack: %s", err, debug.Stack())
 		}
 		handleGracefulShutdown()
-		if log.ErrorCount() > 0 {
+		// In daemon mode errors are scoped to a single run, so they don't affect the exit code
+		if daemonFailed || (!daemonMode() && log.ErrorCount() > 0) {
 			os.Exit(1)
 		}
 	}()
@@ -36,7 +54,8 @@ func main() {
 	if err := validateFlags(); err != nil {
 		log.Fatal("Failed to validate the flags, err: %v", err)
 	}
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	updater, err := updater.NewUpdater(ctx, *configPath)
 	if err != nil {
 		log.Fatal("Failed to construct new Updater, err: %v", err)
@@ -47,15 +66,127 @@ func main() {
 		log.SetWriters(os.Stdout, logUploader.Writer())
 		logUploader.Run()
 	}
-	if err := updater.Run(ctx); err != nil {
-		log.Error("Runtime error occured, err: %v", err)
+	if mode := core.DryRunMode(*dryRun); mode != core.DryRunNone {
+		changes, err := updater.Plan(ctx, mode)
+		if err != nil {
+			log.Error("Runtime error occured, err: %v", err)
+		}
+		if err := printPlan(os.Stdout, changes, *output); err != nil {
+			log.Error("Failed to print the dry run changes, err: %v", err)
+		}
+		return
+	}
+	if daemonMode() {
+		if err := runDaemon(ctx, updater); err != nil {
+			log.Error("Daemon stopped, err: %v", err)
+			daemonFailed = true
+		}
+		return
+	}
+	ran, err := updater.RunOnceAsLeader(ctx, func(ctx context.Context) {
+		if err := updater.Run(ctx); err != nil {
+			log.Error("Runtime error occured, err: %v", err)
+		}
+	})
+	if err != nil {
+		log.Error("Failed to run as leader, err: %v", err)
+	} else if !ran {
+		log.Warn("Another updater instance holds the leader election lease, skipping the run")
+	}
+	if *metricsPush != "" {
+		pushMetrics()
+	}
+}
+
+// runDaemon runs the updater on its schedule until ctx is done. It returns an error if the server
+// of --metrics-addr fails, after stopping the runs the same way a shutdown signal does.
+func runDaemon(ctx context.Context, u *updater.Updater) error {
+	var sch *scheduler.Scheduler
+	if *schedule != "" {
+		var err error
+		if sch, err = scheduler.NewCron(*schedule, *jitter); err != nil {
+			log.Fatal("Failed to parse the schedule, err: %v", err)
+		}
+		log.Info("Updater is running in daemon mode with schedule %q", *schedule)
+	} else {
+		sch = scheduler.NewInterval(*interval, *jitter)
+		log.Info("Updater is running in daemon mode with interval %s", *interval)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	serveErr := make(chan error, 1)
+	if *metricsAddr != "" {
+		go func() {
+			if err := serve(ctx, u, sch); err != nil {
+				serveErr <- err
+				cancel()
+			}
+		}()
+	}
+	// The schedule restarts whenever the leader election lease is acquired again after losing it
+	err := u.RunAsLeader(ctx, func(ctx context.Context) {
+		sch.Run(ctx, func(ctx context.Context) {
+			if err := u.Run(ctx); err != nil {
+				log.Error("Runtime error occured, err: %v", err)
+			}
+		})
+	})
+	if err != nil {
+		log.Fatal("Failed to run as leader, err: %v", err)
+	}
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("failed to serve on %s, err: %v", *metricsAddr, err)
+	default:
+	}
+	log.Info("Received shutdown signal, stopping the daemon")
+	return nil
+}
+
+// pushMetrics pushes the metrics of a one-shot run to --metrics-push-url. The run has already
+// completed, so failing to push is only logged.
+func pushMetrics() {
+	ctx, cancel := context.WithTimeout(context.Background(), metricsPushTimeout)
+	defer cancel()
+	if err := metrics.Push(ctx, *metricsPush, metrics.DefaultPushJob); err != nil {
+		log.Warn("Failed to push metrics to %s, err: %v", *metricsPush, err)
 	}
 }
 
+func daemonMode() bool {
+	return *interval > 0 || *schedule != ""
+}
+
 func validateFlags() error {
 	if *configPath == "" {
 		return errors.New("--config must be specified")
 	}
+	switch core.DryRunMode(*dryRun) {
+	case core.DryRunNone, core.DryRunClient, core.DryRunServer:
+	default:
+		return fmt.Errorf("--dry-run must be one of none, client or server, got %q", *dryRun)
+	}
+	if *output != outputTable && *output != outputJSON {
+		return fmt.Errorf("--output must be one of table or json, got %q", *output)
+	}
+	if *interval < 0 || *jitter < 0 {
+		return errors.New("--interval and --jitter can not be negative")
+	}
+	if *liveness <= 0 {
+		return errors.New("--liveness-threshold must be positive")
+	}
+	if *interval > 0 && *schedule != "" {
+		return errors.New("only one of --interval and --schedule can be specified")
+	}
+	if daemonMode() && core.DryRunMode(*dryRun) != core.DryRunNone {
+		return errors.New("--dry-run can not be used in daemon mode")
+	}
+	if *metricsAddr != "" && !daemonMode() {
+		return errors.New("--metrics-addr can only be used in daemon mode, use --metrics-push-url for one-shot runs")
+	}
+	if *metricsPush != "" && (daemonMode() || core.DryRunMode(*dryRun) != core.DryRunNone) {
+		return errors.New("--metrics-push-url can only be used for one-shot runs without --dry-run")
+	}
 	return nil
 }
 
@@ -67,19 +198,15 @@ func handleGracefulShutdown() {
 	// It's important to first remove the log uploader's writer from logger and then
 	// stop the log uploader to prevent memory leak
 	log.SetWriters(os.Stdout)
-	logUploaderStopped := logUploader.StopBlocking()
+	ctx, cancel := context.WithTimeout(context.Background(), gracefulShutdownPeriod)
+	defer cancel()
+	logUploaderStopped := logUploader.StopBlocking(ctx)
 
 	log.Info("Shutdown period %.0fm started", gracefulShutdownPeriod.Minutes())
-	t := time.NewTimer(gracefulShutdownPeriod)
-
-	for {
-		select {
-		case <-logUploaderStopped:
-			log.Info("Log uploader %s stopped", logUploader.Name())
-			return
-		case <-t.C:
-			log.Warn("Could not stop log uploader %s within the graceful shutdown period (%.0fm)", logUploader.Name(), gracefulShutdownPeriod.Minutes())
-			return
-		}
+	select {
+	case <-logUploaderStopped:
+		log.Info("Log uploader %s stopped", logUploader.Name())
+	case <-ctx.Done():
+		log.Warn("Could not stop log uploader %s within the graceful shutdown period (%.0fm)", logUploader.Name(), gracefulShutdownPeriod.Minutes())
 	}
 }
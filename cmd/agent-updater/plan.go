@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/edgedelta/updater/core"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+func printPlan(w io.Writer, changes []*core.PathChange, format string) error {
+	switch format {
+	case outputJSON:
+		b, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return fmt.Errorf("json.MarshalIndent: %v", err)
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	case outputTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ENTITY\tPATH\tCURRENT\tTARGET\tACTION\tERROR")
+		for _, c := range changes {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", c.EntityID, c.Path, orNone(c.Current), orNone(c.Target), c.Action, c.Error)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("unknown output format: %q", format)
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/edgedelta/updater"
+	"github.com/edgedelta/updater/log"
+	"github.com/edgedelta/updater/metrics"
+	"github.com/edgedelta/updater/scheduler"
+)
+
+const (
+	serverReadTimeout     = 10 * time.Second
+	serverShutdownTimeout = 10 * time.Second
+	readinessTimeout      = 5 * time.Second
+)
+
+// serve serves the metrics and the health endpoints on --metrics-addr until ctx is done. It returns
+// an error if the server fails, e.g. because the address is in use.
+func serve(ctx context.Context, u *updater.Updater, sch *scheduler.Scheduler) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, sch.Check(time.Now(), *liveness))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+		writeHealth(w, u.Ready(ctx))
+	})
+	srv := &http.Server{Addr: *metricsAddr, Handler: mux, ReadHeaderTimeout: serverReadTimeout}
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), serverShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Warn("Failed to shut down the HTTP server, err: %v", err)
+		}
+	}()
+	log.Info("Serving metrics and health endpoints on %s", *metricsAddr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// writeHealth responds with 200 if err is nil, otherwise with 503 and the error.
+func writeHealth(w http.ResponseWriter, err error) {
+	if err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "%v\n", err)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
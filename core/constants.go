@@ -1,5 +1,8 @@
 package core
 
+// K8sResourceKind is any resource name the K8s API server can resolve through discovery, e.g.
+// a short name (ds), a resource name (cronjobs) or a fully qualified resource
+// (rollouts.v1alpha1.argoproj.io). The constants below are the commonly used short names.
 type K8sResourceKind string
 
 const (
@@ -9,6 +12,8 @@ const (
 )
 
 var (
+	// Deprecated: Any kind the K8s API server can resolve is supported, this only lists the
+	// short names of the kinds supported before.
 	SupportedK8sResourceKinds = map[K8sResourceKind]bool{
 		K8sDaemonset:   true,
 		K8sDeployment:  true,
@@ -0,0 +1,88 @@
+package core
+
+import "strings"
+
+const (
+	dockerHubRegistry = "docker.io"
+)
+
+// ImageReference is a parsed container image reference such as
+// "gcr.io/org/image:v1.2.3@sha256:...". Registry and Repository keep the form they are written in.
+type ImageReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseImageReference parses the given image reference. It does not validate the reference, every
+// string results in a reference with at least a repository.
+func ParseImageReference(ref string) ImageReference {
+	var r ImageReference
+	if i := strings.Index(ref, "@"); i >= 0 {
+		r.Digest = ref[i+1:]
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, ":"); i >= 0 && !strings.Contains(ref[i+1:], "/") {
+		r.Tag = ref[i+1:]
+		ref = ref[:i]
+	}
+	if i := strings.Index(ref, "/"); i >= 0 {
+		first := ref[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" {
+			r.Registry = first
+			ref = ref[i+1:]
+		}
+	}
+	r.Repository = ref
+	return r
+}
+
+// Name returns the reference without its tag and digest.
+func (r ImageReference) Name() string {
+	if r.Registry == "" {
+		return r.Repository
+	}
+	return r.Registry + "/" + r.Repository
+}
+
+// RegistryHost returns the registry of the reference, defaulting to Docker Hub.
+func (r ImageReference) RegistryHost() string {
+	if r.Registry == "" {
+		return dockerHubRegistry
+	}
+	return r.Registry
+}
+
+// RegistryRepository returns the repository name as known by the registry, i.e. with the "library"
+// namespace for the official Docker Hub images.
+func (r ImageReference) RegistryRepository() string {
+	if r.RegistryHost() == dockerHubRegistry && !strings.Contains(r.Repository, "/") {
+		return "library/" + r.Repository
+	}
+	return r.Repository
+}
+
+func (r ImageReference) String() string {
+	s := r.Name()
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// ImageTag returns the tag of the given image reference (e.g. "v1.2.3" of
+// "gcr.io/org/image:v1.2.3"), or an empty string if the reference has no tag.
+func ImageTag(ref string) string {
+	return ParseImageReference(ref).Tag
+}
+
+// DigestEquivalent reports whether both image references pin the same digest of the same image,
+// regardless of their tags.
+func DigestEquivalent(a, b string) bool {
+	ra, rb := ParseImageReference(a), ParseImageReference(b)
+	return ra.Digest != "" && ra.Digest == rb.Digest && ra.Name() == rb.Name()
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+)
+
+// ImagePolicy restricts the image references which can be written into the K8s resource paths.
+// Empty lists and patterns allow everything.
+type ImagePolicy struct {
+	// Registries are the allowed registry hosts, e.g. "gcr.io". Images without a registry are
+	// served by "docker.io"
+	Registries []string `yaml:"registries,omitempty"`
+	// Repositories are the allowed repository name patterns in path.Match syntax, e.g.
+	// "edgedelta/*". Official Docker Hub images are in the "library" namespace
+	Repositories []string `yaml:"repositories,omitempty"`
+	// TagPattern is a regular expression the whole image tags must match, i.e. it is anchored at
+	// both ends
+	TagPattern string `yaml:"tag_pattern,omitempty"`
+
+	tagRe *regexp.Regexp
+}
+
+func (p *ImagePolicy) Validate() error {
+	for _, r := range p.Repositories {
+		if _, err := path.Match(r, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q, err: %v", r, err)
+		}
+	}
+	if p.TagPattern != "" {
+		re, err := regexp.Compile(`^(?:` + p.TagPattern + `)$`)
+		if err != nil {
+			return fmt.Errorf("invalid tag pattern %q, err: %v", p.TagPattern, err)
+		}
+		p.tagRe = re
+	}
+	return nil
+}
+
+// Check returns an error if the given image reference is not allowed by the policy. Validate must
+// be called before.
+func (p *ImagePolicy) Check(image string) error {
+	ref := ParseImageReference(image)
+	if len(p.Registries) > 0 && !containsString(p.Registries, ref.RegistryHost()) {
+		return fmt.Errorf("registry %s of image %s is not allowed", ref.RegistryHost(), image)
+	}
+	if len(p.Repositories) > 0 {
+		allowed := false
+		for _, r := range p.Repositories {
+			if ok, _ := path.Match(r, ref.RegistryRepository()); ok {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("repository %s of image %s is not allowed", ref.RegistryRepository(), image)
+		}
+	}
+	if p.tagRe != nil && !p.tagRe.MatchString(ref.Tag) {
+		return fmt.Errorf("tag %q of image %s does not match pattern %q", ref.Tag, image, p.TagPattern)
+	}
+	return nil
+}
+
+func containsString(l []string, s string) bool {
+	for _, e := range l {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package core
+
+import "testing"
+
+func TestImagePolicyCheck(t *testing.T) {
+	policy := &ImagePolicy{
+		Registries:   []string{"gcr.io", "docker.io"},
+		Repositories: []string{"edgedelta/*", "library/busybox"},
+		TagPattern:   `^v\d+\.\d+\.\d+$`,
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		image   string
+		wantErr bool
+	}{
+		{image: "gcr.io/edgedelta/agent:v0.1.47"},
+		{image: "edgedelta/agent:v0.1.47"},
+		{image: "busybox:v1.36.0"},
+		{image: "gcr.io/edgedelta/agent:v0.1.47@sha256:0123456789abcdef"},
+		{image: "evil.io/edgedelta/agent:v0.1.47", wantErr: true},
+		{image: "gcr.io/other/agent:v0.1.47", wantErr: true},
+		{image: "gcr.io/edgedelta/team/agent:v0.1.47", wantErr: true},
+		{image: "gcr.io/edgedelta/agent:latest", wantErr: true},
+		{image: "gcr.io/edgedelta/agent@sha256:0123456789abcdef", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.image, func(t *testing.T) {
+			err := policy.Check(tc.image)
+			if tc.wantErr && err == nil {
+				t.Error("Wanted an error, got nil instead")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Wanted no error, got %v instead", err)
+			}
+		})
+	}
+}
+
+func TestImagePolicyValidate(t *testing.T) {
+	for _, p := range []*ImagePolicy{{Repositories: []string{"edgedelta/["}}, {TagPattern: "v("}} {
+		if err := p.Validate(); err == nil {
+			t.Errorf("Wanted an error for policy %+v, got nil instead", p)
+		}
+	}
+}
+
+func TestImagePolicyTagPatternIsAnchored(t *testing.T) {
+	policy := &ImagePolicy{TagPattern: `v\d+\.\d+\.\d+`}
+	if err := policy.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.Check("gcr.io/edgedelta/agent:v1.2.3"); err != nil {
+		t.Errorf("Wanted no error, got %v instead", err)
+	}
+	for _, image := range []string{"gcr.io/edgedelta/agent:evil-v1.2.3-debug", "gcr.io/edgedelta/agent:v1.2.3-debug"} {
+		if err := policy.Check(image); err == nil {
+			t.Errorf("Wanted an error for image %s, got nil instead", image)
+		}
+	}
+}
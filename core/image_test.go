@@ -0,0 +1,82 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseImageReference(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want ImageReference
+	}{
+		{
+			ref:  "gcr.io/my-org/image:v0.1.47",
+			want: ImageReference{Registry: "gcr.io", Repository: "my-org/image", Tag: "v0.1.47"},
+		},
+		{
+			ref:  "localhost:5000/image",
+			want: ImageReference{Registry: "localhost:5000", Repository: "image"},
+		},
+		{
+			ref:  "my-org/image:v1@sha256:0123456789abcdef",
+			want: ImageReference{Repository: "my-org/image", Tag: "v1", Digest: "sha256:0123456789abcdef"},
+		},
+		{
+			ref:  "image@sha256:0123456789abcdef",
+			want: ImageReference{Repository: "image", Digest: "sha256:0123456789abcdef"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			got := ParseImageReference(tc.ref)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Reference mismatch (-want +got):\n%s", diff)
+			}
+			if got.String() != tc.ref {
+				t.Errorf("Wanted string form %s, got %s instead", tc.ref, got.String())
+			}
+		})
+	}
+}
+
+func TestImageTag(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "gcr.io/my-org/image:v0.1.47", want: "v0.1.47"},
+		{ref: "localhost:5000/image:v0.1.47", want: "v0.1.47"},
+		{ref: "localhost:5000/image", want: ""},
+		{ref: "image@sha256:0123456789abcdef", want: ""},
+		{ref: "image:v1@sha256:0123456789abcdef", want: "v1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.ref, func(t *testing.T) {
+			if got := ImageTag(tc.ref); got != tc.want {
+				t.Errorf("Wanted tag %q, got %q instead", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestDigestEquivalent(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "gcr.io/my-org/image:v1@sha256:abc", b: "gcr.io/my-org/image@sha256:abc", want: true},
+		{a: "gcr.io/my-org/image:v1@sha256:abc", b: "gcr.io/my-org/image:v2@sha256:abc", want: true},
+		{a: "gcr.io/my-org/image:v1@sha256:abc", b: "gcr.io/my-org/image:v1@sha256:def", want: false},
+		{a: "gcr.io/my-org/image:v1@sha256:abc", b: "gcr.io/other/image@sha256:abc", want: false},
+		{a: "gcr.io/my-org/image:v1", b: "gcr.io/my-org/image:v1", want: false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.a+"_"+tc.b, func(t *testing.T) {
+			if got := DigestEquivalent(tc.a, tc.b); got != tc.want {
+				t.Errorf("Wanted %t, got %t instead", tc.want, got)
+			}
+		})
+	}
+}
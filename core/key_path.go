@@ -0,0 +1,145 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// KeyPathSegment is a dot-separated segment of a key path, such as "spec", "containers[0]",
+// "containers[name=agent]" or "containers[*]".
+type KeyPathSegment struct {
+	Field string
+	// Element selects an element of the field's slice, nil if the field is not a slice
+	Element *ElementSelector
+}
+
+// ElementSelector selects slice elements by index, e.g. "0", by the value of one of their fields,
+// e.g. "name=agent", by a regular expression matching one of their fields, e.g.
+// "image~=edgedelta/agent", or all of them with "*". Index and exact value selectors select a
+// single element, the others any number of elements.
+type ElementSelector struct {
+	// Index is the index of the element, -1 if the element is not selected by index
+	Index int
+	Key   string
+	Value string
+	// Regexp marks Value as an unanchored regular expression rather than an exact value
+	Regexp bool
+	// All selects all elements
+	All bool
+}
+
+// Multi reports whether the selector can select more than one element.
+func (s *ElementSelector) Multi() bool {
+	return s.All || s.Regexp
+}
+
+func (s *ElementSelector) String() string {
+	switch {
+	case s.All:
+		return "*"
+	case s.Index >= 0:
+		return strconv.Itoa(s.Index)
+	case s.Regexp:
+		return s.Key + "~=" + s.Value
+	}
+	return s.Key + "=" + s.Value
+}
+
+// SplitKeyPath splits the given key path into its segments. Dots inside the brackets of an element
+// selector do not separate segments, so selector values can contain dots.
+func SplitKeyPath(path string) ([]string, error) {
+	segments := make([]string, 0)
+	depth, start := 0, 0
+	for i, c := range path {
+		switch c {
+		case '[':
+			if depth > 0 {
+				return nil, fmt.Errorf("nested brackets in key path %q", path)
+			}
+			depth++
+		case ']':
+			if depth == 0 {
+				return nil, fmt.Errorf("unbalanced brackets in key path %q", path)
+			}
+			depth--
+		case '.':
+			if depth == 0 {
+				segments = append(segments, path[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("unbalanced brackets in key path %q", path)
+	}
+	segments = append(segments, path[start:])
+	for _, s := range segments {
+		if s == "" {
+			return nil, fmt.Errorf("empty segment in key path %q", path)
+		}
+	}
+	return segments, nil
+}
+
+// ParseKeyPathSegment parses a single key path segment.
+func ParseKeyPathSegment(segment string) (KeyPathSegment, error) {
+	open := strings.Index(segment, "[")
+	if open < 0 {
+		if strings.Contains(segment, "]") {
+			return KeyPathSegment{}, fmt.Errorf("unbalanced brackets in segment %q", segment)
+		}
+		return KeyPathSegment{Field: segment}, nil
+	}
+	if open == 0 || !strings.HasSuffix(segment, "]") {
+		return KeyPathSegment{}, fmt.Errorf("invalid slice segment %q, expected <field>[<selector>]", segment)
+	}
+	sel, err := parseElementSelector(segment[open+1 : len(segment)-1])
+	if err != nil {
+		return KeyPathSegment{}, fmt.Errorf("invalid selector in segment %q, err: %v", segment, err)
+	}
+	return KeyPathSegment{Field: segment[:open], Element: sel}, nil
+}
+
+func parseElementSelector(s string) (*ElementSelector, error) {
+	if s == "*" {
+		return &ElementSelector{Index: -1, All: true}, nil
+	}
+	if key, value, ok := strings.Cut(s, "="); ok {
+		sel := &ElementSelector{Index: -1, Key: key, Value: value}
+		if strings.HasSuffix(key, "~") {
+			sel.Key, sel.Regexp = strings.TrimSuffix(key, "~"), true
+			if _, err := regexp.Compile(value); err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q, err: %v", value, err)
+			}
+		}
+		if sel.Key == "" {
+			return nil, errors.New("selector has no field name")
+		}
+		return sel, nil
+	}
+	i, err := strconv.ParseUint(s, 10, 31)
+	if err != nil {
+		return nil, fmt.Errorf("expected an index or <field>=<value>, got %q", s)
+	}
+	return &ElementSelector{Index: int(i)}, nil
+}
+
+// ParseKeyPath splits the given key path and parses each of its segments.
+func ParseKeyPath(path string) ([]KeyPathSegment, error) {
+	split, err := SplitKeyPath(path)
+	if err != nil {
+		return nil, err
+	}
+	segments := make([]KeyPathSegment, 0, len(split))
+	for _, s := range split {
+		seg, err := ParseKeyPathSegment(s)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+	return segments, nil
+}
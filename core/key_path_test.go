@@ -0,0 +1,81 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseKeyPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []KeyPathSegment
+		wantErr bool
+	}{
+		{
+			path: "spec.template.spec.containers[0].image",
+			want: []KeyPathSegment{
+				{Field: "spec"}, {Field: "template"}, {Field: "spec"},
+				{Field: "containers", Element: &ElementSelector{Index: 0}},
+				{Field: "image"},
+			},
+		},
+		{
+			path: "spec.template.spec.initContainers[name=setup.v2].image",
+			want: []KeyPathSegment{
+				{Field: "spec"}, {Field: "template"}, {Field: "spec"},
+				{Field: "initContainers", Element: &ElementSelector{Index: -1, Key: "name", Value: "setup.v2"}},
+				{Field: "image"},
+			},
+		},
+		{
+			path: "spec.containers[*].image",
+			want: []KeyPathSegment{
+				{Field: "spec"},
+				{Field: "containers", Element: &ElementSelector{Index: -1, All: true}},
+				{Field: "image"},
+			},
+		},
+		{
+			path: "spec.containers[image~=edgedelta/agent(:|@)].image",
+			want: []KeyPathSegment{
+				{Field: "spec"},
+				{Field: "containers", Element: &ElementSelector{Index: -1, Key: "image", Value: "edgedelta/agent(:|@)", Regexp: true}},
+				{Field: "image"},
+			},
+		},
+		{path: "spec.containers[image~=(].image", wantErr: true},
+		{path: "spec.containers[~=agent].image", wantErr: true},
+		{
+			path: "spec.containers[0].env[name=AGENT_VERSION]",
+			want: []KeyPathSegment{
+				{Field: "spec"},
+				{Field: "containers", Element: &ElementSelector{Index: 0}},
+				{Field: "env", Element: &ElementSelector{Index: -1, Key: "name", Value: "AGENT_VERSION"}},
+			},
+		},
+		{path: "spec.containers[name=agent.image", wantErr: true},
+		{path: "spec.containers]0[.image", wantErr: true},
+		{path: "spec..image", wantErr: true},
+		{path: "spec.containers[agent].image", wantErr: true},
+		{path: "spec.containers[=agent].image", wantErr: true},
+		{path: "spec.[0].image", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			got, err := ParseKeyPath(tc.path)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Wanted an error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Segments mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -2,20 +2,40 @@ package core
 
 import (
 	"errors"
+	"fmt"
 	"strings"
 )
 
 type K8sResourcePath string
 
+const (
+	// AllNamespaces is the namespace of a resource path which targets the matching resources of
+	// all namespaces
+	AllNamespaces = "*"
+	// selectorPrefix prefixes the label selector in place of the name of a resource path
+	selectorPrefix = "selector="
+)
+
+// K8sResourceIdentifier identifies the K8s resources and the key path of a resource path. A resource
+// path either targets a single resource by its namespace and name, e.g. "default:ds/my-agent:...",
+// or the resources matching a label selector, e.g.
+// "*:ds/selector=app.kubernetes.io/name=edgedelta:...". The namespace is AllNamespaces to target
+// the resources of every namespace.
 type K8sResourceIdentifier struct {
-	Namespace     string
-	Kind          K8sResourceKind
-	Name          string
+	Namespace string
+	Kind      K8sResourceKind
+	Name      string
+	// Selector is the label selector of the targeted resources, empty if a single resource is
+	// targeted by name
+	Selector      string
 	UpdateKeyPath string
+	// KeyPath is UpdateKeyPath split into its segments
+	KeyPath []string
 }
 
 func (rp K8sResourcePath) Parse() (*K8sResourceIdentifier, error) {
-	sp := strings.Split(string(rp), ":")
+	// Key paths can have colons in their element selectors, e.g. "containers[image=host:5000/image]"
+	sp := strings.SplitN(string(rp), ":", 3)
 	if len(sp) != 3 {
 		return nil, errors.New("invalid schema, wrong number of semicolon-separated items")
 	}
@@ -23,11 +43,51 @@ func (rp K8sResourcePath) Parse() (*K8sResourceIdentifier, error) {
 		Namespace:     sp[0],
 		UpdateKeyPath: sp[2],
 	}
-	sp = strings.Split(sp[1], "/")
-	if len(sp) != 2 {
+	// Label selector keys can have slashes, e.g. "selector=app.kubernetes.io/name=edgedelta"
+	sp = strings.SplitN(sp[1], "/", 2)
+	if len(sp) != 2 || (strings.Contains(sp[1], "/") && !strings.HasPrefix(sp[1], selectorPrefix)) {
 		return nil, errors.New("invalid schema, wrong number of slash-separated items")
 	}
 	ri.Kind = K8sResourceKind(sp[0])
-	ri.Name = sp[1]
+	if strings.HasPrefix(sp[1], selectorPrefix) {
+		ri.Selector = strings.TrimPrefix(sp[1], selectorPrefix)
+		if ri.Selector == "" {
+			return nil, errors.New("invalid schema, empty label selector")
+		}
+	} else {
+		ri.Name = sp[1]
+	}
+	if _, err := ParseKeyPath(ri.UpdateKeyPath); err != nil {
+		return nil, fmt.Errorf("invalid key path, err: %v", err)
+	}
+	ri.KeyPath, _ = SplitKeyPath(ri.UpdateKeyPath) // Already validated by ParseKeyPath
 	return ri, nil
 }
+
+// Object returns the part of the resource path that identifies the K8s objects, i.e. without the
+// update key path.
+func (ri *K8sResourceIdentifier) Object() string {
+	if ri.Selector != "" {
+		return fmt.Sprintf("%s:%s/%s%s", ri.Namespace, ri.Kind, selectorPrefix, ri.Selector)
+	}
+	return fmt.Sprintf("%s:%s/%s", ri.Namespace, ri.Kind, ri.Name)
+}
+
+// Multi reports whether the identifier can target more than one K8s object, i.e. it has a label
+// selector or targets all namespaces.
+func (ri *K8sResourceIdentifier) Multi() bool {
+	return ri.Selector != "" || ri.Namespace == AllNamespaces
+}
+
+// ForObject returns the identifier of the single K8s object with the given namespace and name
+// among the ones targeted by ri.
+func (ri *K8sResourceIdentifier) ForObject(namespace, name string) *K8sResourceIdentifier {
+	o := *ri
+	o.Namespace, o.Name, o.Selector = namespace, name, ""
+	return &o
+}
+
+// Path returns the resource path of the identified object with the given key path.
+func (ri *K8sResourceIdentifier) Path(keyPath []string) K8sResourcePath {
+	return K8sResourcePath(fmt.Sprintf("%s:%s", ri.Object(), strings.Join(keyPath, ".")))
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestK8sResourcePathParse(t *testing.T) {
+	tests := []struct {
+		path    K8sResourcePath
+		want    *K8sResourceIdentifier
+		wantErr bool
+	}{
+		{
+			path: "default:ds/my-agent:spec.template.spec.containers[image=localhost:5000/agent].image",
+			want: &K8sResourceIdentifier{
+				Namespace:     "default",
+				Kind:          "ds",
+				Name:          "my-agent",
+				UpdateKeyPath: "spec.template.spec.containers[image=localhost:5000/agent].image",
+				KeyPath:       []string{"spec", "template", "spec", "containers[image=localhost:5000/agent]", "image"},
+			},
+		},
+		{
+			path: "*:ds/selector=app.kubernetes.io/name=edgedelta,tier!=canary:spec.template.spec.containers[0].image",
+			want: &K8sResourceIdentifier{
+				Namespace:     "*",
+				Kind:          "ds",
+				Selector:      "app.kubernetes.io/name=edgedelta,tier!=canary",
+				UpdateKeyPath: "spec.template.spec.containers[0].image",
+				KeyPath:       []string{"spec", "template", "spec", "containers[0]", "image"},
+			},
+		},
+		{path: "default:ds/my/agent:spec.image", wantErr: true},
+		{path: "default:ds/selector=:spec.image", wantErr: true},
+		{path: "default:ds:spec.image", wantErr: true},
+		{path: "default:ds/my-agent", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.path), func(t *testing.T) {
+			got, err := tc.path.Parse()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Wanted an error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Identifier mismatch (-want +got):\n%s", diff)
+			}
+			if got.Object()+":"+got.UpdateKeyPath != string(tc.path) {
+				t.Errorf("Wanted object %s, got %s instead", tc.path, got.Object())
+			}
+		})
+	}
+}
+
+func TestK8sResourceIdentifierForObject(t *testing.T) {
+	ri, err := K8sResourcePath("*:ds/selector=app=edgedelta:spec.template.spec.containers[*].image").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ri.Multi() {
+		t.Fatal("Wanted a multi-object identifier")
+	}
+	o := ri.ForObject("monitoring", "edgedelta")
+	if o.Multi() {
+		t.Fatal("Wanted a single object identifier")
+	}
+	want := K8sResourcePath("monitoring:ds/edgedelta:spec.template.spec.containers[1].image")
+	if got := o.Path([]string{"spec", "template", "spec", "containers[1]", "image"}); got != want {
+		t.Errorf("Wanted path %s, got %s instead", want, got)
+	}
+}
@@ -0,0 +1,243 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// SignaturePayload returns the canonical payload signed for a latest tag response: the JSON object
+// {"digest":"<digest>","image":"<url>","tag":"<tag>"} serialized with the JSON Canonicalization
+// Scheme (RFC 8785), where the digest is empty if the response has none. The value changes of the
+// response, if any, are signed too as the leading "changes" list of {"path","value","type"} objects
+// in the order of the response, an empty type omitted.
+func SignaturePayload(res *LatestTagResponse) []byte {
+	b, _ := json.Marshal(struct {
+		Changes []ValueChange `json:"changes,omitempty"`
+		Digest  string        `json:"digest"`
+		Image   string        `json:"image"`
+		Tag     string        `json:"tag"`
+	}{Changes: res.Changes, Digest: res.Digest, Image: res.URL, Tag: res.Tag})
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return b
+	}
+	return appendCanonicalJSON(nil, v)
+}
+
+// appendCanonicalJSON appends v, as decoded by json.Unmarshal, serialized with the JSON
+// Canonicalization Scheme: no whitespace, object keys sorted by their UTF-16 code units, strings
+// with minimal escaping and numbers formatted like ECMAScript does.
+func appendCanonicalJSON(b []byte, v any) []byte {
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case bool:
+		return strconv.AppendBool(b, v)
+	case float64:
+		return appendCanonicalNumber(b, v)
+	case string:
+		return appendCanonicalString(b, v)
+	case []any:
+		b = append(b, '[')
+		for i, e := range v {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendCanonicalJSON(b, e)
+		}
+		return append(b, ']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		b = append(b, '{')
+		for i, k := range keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendCanonicalString(b, k)
+			b = append(b, ':')
+			b = appendCanonicalJSON(b, v[k])
+		}
+		return append(b, '}')
+	}
+	return b
+}
+
+func appendCanonicalNumber(b []byte, f float64) []byte {
+	if f == 0 {
+		// Also formats -0 as 0
+		return append(b, '0')
+	}
+	format := byte('f')
+	if abs := math.Abs(f); abs < 1e-6 || abs >= 1e21 {
+		format = 'e'
+	}
+	b = strconv.AppendFloat(b, f, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+func appendCanonicalString(b []byte, s string) []byte {
+	const hex = "0123456789abcdef"
+	b = append(b, '"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			b = append(b, '\\', byte(r))
+		case '\b':
+			b = append(b, '\\', 'b')
+		case '\f':
+			b = append(b, '\\', 'f')
+		case '\n':
+			b = append(b, '\\', 'n')
+		case '\r':
+			b = append(b, '\\', 'r')
+		case '\t':
+			b = append(b, '\\', 't')
+		default:
+			if r < 0x20 {
+				b = append(b, '\\', 'u', '0', '0', hex[r>>4], hex[r&0xf])
+			} else {
+				b = utf8.AppendRune(b, r)
+			}
+		}
+	}
+	return append(b, '"')
+}
+
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
+
+// SignatureVerifier verifies the signatures of the latest tag responses against a set of public
+// keys. It does not need network access.
+type SignatureVerifier struct {
+	keys []crypto.PublicKey
+}
+
+func NewSignatureVerifier(conf *SignatureConfig) (*SignatureVerifier, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	v := &SignatureVerifier{}
+	pems := make([]string, 0, len(conf.PublicKeys)+len(conf.PublicKeyFiles))
+	pems = append(pems, conf.PublicKeys...)
+	for _, f := range conf.PublicKeyFiles {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read public key file %s, err: %v", f, err)
+		}
+		pems = append(pems, string(b))
+	}
+	for _, p := range pems {
+		keys, err := parsePublicKeys([]byte(p))
+		if err != nil {
+			return nil, err
+		}
+		v.keys = append(v.keys, keys...)
+	}
+	if len(v.keys) == 0 {
+		return nil, errors.New("no public key is configured for signature verification")
+	}
+	return v, nil
+}
+
+// parsePublicKeys parses all PEM blocks of the given data, so that a single K8s secret can hold
+// several keys.
+func parsePublicKeys(data []byte) ([]crypto.PublicKey, error) {
+	keys := make([]crypto.PublicKey, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("x509.ParsePKIXPublicKey: %v", err)
+		}
+		switch key.(type) {
+		case ed25519.PublicKey, *ecdsa.PublicKey:
+		default:
+			return nil, fmt.Errorf("unsupported public key type %T, must be ed25519 or ECDSA", key)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no PEM encoded public key found")
+	}
+	return keys, nil
+}
+
+// Verify returns nil if the given base64 encoded signature of the response's canonical payload
+// is valid for one of the public keys. ed25519 signatures are over the payload itself, ECDSA
+// signatures are ASN.1 encoded and over the SHA-256, SHA-384 or SHA-512 digest of the payload
+// for P-256, P-384 and P-521 keys respectively.
+func (v *SignatureVerifier) Verify(res *LatestTagResponse, signature string) error {
+	if signature == "" {
+		return fmt.Errorf("image %s is not signed", res.URL)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature of image %s, err: %v", res.URL, err)
+	}
+	payload := SignaturePayload(res)
+	for _, key := range v.keys {
+		switch k := key.(type) {
+		case ed25519.PublicKey:
+			if ed25519.Verify(k, payload, sig) {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			if ecdsa.VerifyASN1(k, ecdsaDigest(k.Curve, payload), sig) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature of image %s is not valid for any of the public keys", res.URL)
+}
+
+func ecdsaDigest(curve elliptic.Curve, payload []byte) []byte {
+	switch curve.Params().BitSize {
+	case 384:
+		h := sha512.Sum384(payload)
+		return h[:]
+	case 521:
+		h := sha512.Sum512(payload)
+		return h[:]
+	}
+	h := sha256.Sum256(payload)
+	return h[:]
+}
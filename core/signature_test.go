@@ -0,0 +1,170 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestSignatureVerifier(t *testing.T) {
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "ecdsa.pem")
+	if err := os.WriteFile(keyFile, []byte(publicKeyPEM(t, &ecPriv.PublicKey)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewSignatureVerifier(&SignatureConfig{PublicKeys: []string{publicKeyPEM(t, edPub)}, PublicKeyFiles: []string{keyFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := &LatestTagResponse{Tag: "v1.2.3", Image: "agent", URL: "gcr.io/my-org/agent:v1.2.3", Digest: "sha256:abc"}
+	payload := SignaturePayload(res)
+	if want := `{"digest":"sha256:abc","image":"gcr.io/my-org/agent:v1.2.3","tag":"v1.2.3"}`; string(payload) != want {
+		t.Fatalf("Wanted payload %s, got %s instead", want, payload)
+	}
+	ecSig, err := ecdsa.SignASN1(rand.Reader, ecPriv, ecdsaDigest(ecPriv.Curve, payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := *res
+	tampered.URL = "evil.io/my-org/agent:v1.2.3"
+	tamperedTag := *res
+	tamperedTag.Tag = "v2.0.0"
+	withChanges := *res
+	withChanges.Changes = []ValueChange{{Path: "default:ds/agent:spec.replicas", Value: int64(2)}}
+	if want := `{"changes":[{"path":"default:ds/agent:spec.replicas","value":2}],"digest":"sha256:abc","image":"gcr.io/my-org/agent:v1.2.3","tag":"v1.2.3"}`; string(SignaturePayload(&withChanges)) != want {
+		t.Fatalf("Wanted payload %s, got %s instead", want, SignaturePayload(&withChanges))
+	}
+
+	tests := []struct {
+		desc      string
+		res       *LatestTagResponse
+		signature string
+		wantErr   bool
+	}{
+		{
+			desc:      "ed25519 signature",
+			res:       res,
+			signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, payload)),
+		},
+		{
+			desc:      "ECDSA signature",
+			res:       res,
+			signature: base64.StdEncoding.EncodeToString(ecSig),
+		},
+		{
+			desc:      "Tampered image",
+			res:       &tampered,
+			signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, payload)),
+			wantErr:   true,
+		},
+		{
+			desc:      "Tampered tag",
+			res:       &tamperedTag,
+			signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, payload)),
+			wantErr:   true,
+		},
+		{
+			desc:      "Added changes",
+			res:       &withChanges,
+			signature: base64.StdEncoding.EncodeToString(ed25519.Sign(edPriv, payload)),
+			wantErr:   true,
+		},
+		{
+			desc:      "Unknown key",
+			res:       res,
+			signature: base64.StdEncoding.EncodeToString(ed25519.Sign(otherPriv, payload)),
+			wantErr:   true,
+		},
+		{
+			desc:    "Missing signature",
+			res:     res,
+			wantErr: true,
+		},
+		{
+			desc:      "Malformed signature",
+			res:       res,
+			signature: "not base64!",
+			wantErr:   true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := v.Verify(tc.res, tc.signature)
+			if tc.wantErr && err == nil {
+				t.Error("Wanted an error, got nil instead")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Wanted no error, got %v instead", err)
+			}
+		})
+	}
+}
+
+func TestSignaturePayloadIsCanonical(t *testing.T) {
+	tests := []struct {
+		desc  string
+		value any
+		want  string
+	}{
+		{desc: "Integer", value: float64(100), want: `100`},
+		{desc: "Fraction", value: 0.1, want: `0.1`},
+		{desc: "Negative zero", value: math.Copysign(0, -1), want: `0`},
+		{desc: "Large number", value: 1e21, want: `1e+21`},
+		{desc: "Small number", value: 1e-7, want: `1e-7`},
+		{desc: "HTML characters", value: "<a&b>", want: `"<a&b>"`},
+		{desc: "Escaped characters", value: "\"\\\n\u001f\u2028", want: `"\"\\\n\u001f` + "\u2028" + `"`},
+		{desc: "Object keys in UTF-16 order", value: map[string]any{"b": true, "\U0001F600": 1.0, "\uFB01": nil, "a": []any{}}, want: `{"a":[],"b":true,"` + "\U0001F600" + `":1,"` + "\uFB01" + `":null}`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			res := &LatestTagResponse{Tag: "v1", URL: "my-org/agent:v1", Changes: []ValueChange{{Path: "default:ds/agent:spec.x", Value: tc.value}}}
+			want := `{"changes":[{"path":"default:ds/agent:spec.x","value":` + tc.want + `}],"digest":"","image":"my-org/agent:v1","tag":"v1"}`
+			if got := string(SignaturePayload(res)); got != want {
+				t.Errorf("Wanted payload %s, got %s instead", want, got)
+			}
+		})
+	}
+}
+
+func TestNewSignatureVerifierErrors(t *testing.T) {
+	for desc, conf := range map[string]*SignatureConfig{
+		"no keys":       {},
+		"not PEM":       {PublicKeys: []string{"some key"}},
+		"missing file":  {PublicKeyFiles: []string{filepath.Join(t.TempDir(), "missing.pem")}},
+		"malformed PEM": {PublicKeys: []string{"-----BEGIN PUBLIC KEY-----\nAAAA\n-----END PUBLIC KEY-----\n"}},
+	} {
+		if _, err := NewSignatureVerifier(conf); err == nil {
+			t.Errorf("Wanted an error for %s, got nil instead", desc)
+		}
+	}
+}
@@ -1,16 +1,61 @@
 package core
 
+import (
+	"context"
+	"time"
+)
+
 type UpdaterConfig struct {
 	Entities []EntityProperties `yaml:"entities"`
 	API      APIConfig          `yaml:"api"`
-	Log      *LogConfig         `yaml:"log,omitempty"`
-	Metadata map[string]string  `yaml:"-"`
+	Registry *RegistryConfig    `yaml:"registry,omitempty"`
+	Policy   *ImagePolicy       `yaml:"image_policy,omitempty"`
+	K8s      *K8sConfig         `yaml:"k8s,omitempty"`
+	// LeaderElection makes only one of the updater instances update the resources at a time
+	LeaderElection *LeaderElectionConfig `yaml:"leader_election,omitempty"`
+	Log            *LogConfig            `yaml:"log,omitempty"`
+	Metadata       map[string]string     `yaml:"-"`
 }
 
 type EntityProperties struct {
 	ID        string            `yaml:"id"`
 	ImageName string            `yaml:"image"`
 	K8sPaths  []K8sResourcePath `yaml:"paths"`
+	Rollout   *RolloutConfig    `yaml:"rollout,omitempty"`
+	Versions  *VersionPolicy    `yaml:"versions,omitempty"`
+	PinDigest DigestPinning     `yaml:"pin_digest,omitempty"`
+	// Changes are applied together with the image, e.g. an env var or a resource limit required
+	// by the new version
+	Changes []ValueChange `yaml:"changes,omitempty"`
+}
+
+// ValueChange sets a value at a K8s resource path. A string value is a template which can refer to
+// the latest applicable tag with {{ .ctx.tag }}, {{ .ctx.image }} (the written image reference),
+// {{ .ctx.url }} and {{ .ctx.digest }}. Strings are written as they are unless a type is given,
+// other values (numbers, booleans, objects and lists) are written with their own types.
+type ValueChange struct {
+	Path  K8sResourcePath `yaml:"path" json:"path"`
+	Value any             `yaml:"value" json:"value"`
+	Type  ValueType       `yaml:"type,omitempty" json:"type,omitempty"`
+}
+
+// DigestPinning defines whether and how the latest applicable image is pinned to its digest before
+// it is written to the K8s resources.
+type DigestPinning string
+
+const (
+	// DigestPinningNone writes the image reference as it is, e.g. "image:tag".
+	DigestPinningNone DigestPinning = ""
+	// DigestPinningDigest replaces the tag with the digest, e.g. "image@sha256:...".
+	DigestPinningDigest DigestPinning = "digest"
+	// DigestPinningTagDigest keeps the tag next to the digest, e.g. "image:tag@sha256:...".
+	DigestPinningTagDigest DigestPinning = "tag_digest"
+)
+
+type RolloutConfig struct {
+	Wait     bool          `yaml:"wait"`
+	Timeout  time.Duration `yaml:"timeout,omitempty"`
+	Rollback bool          `yaml:"rollback,omitempty"`
 }
 
 type APIConfig struct {
@@ -19,6 +64,56 @@ type APIConfig struct {
 	MetadataEndpoint  *EndpointConfig  `yaml:"metadata,omitempty"`
 	LogUpload         *LogUploadConfig `yaml:"log_upload,omitempty"`
 	TopLevelAuth      *APIAuth         `yaml:"auth,omitempty"`
+	Timeout           time.Duration    `yaml:"timeout,omitempty"`
+	Retry             *RetryConfig     `yaml:"retry,omitempty"`
+	TLS               *TLSConfig       `yaml:"tls,omitempty"`
+	Signature         *SignatureConfig `yaml:"signature,omitempty"`
+	// ReportEndpoint receives the outcome of each entity's update, see UpdateReport
+	ReportEndpoint *EndpointConfig `yaml:"report,omitempty"`
+}
+
+// SignatureConfig requires the latest applicable tags to be signed by one of the public keys.
+// Public keys are PEM encoded ed25519 or ECDSA keys, given inline (which can be read from K8s
+// secrets through config variables) or as file paths. The signature is read from the latest tag
+// response unless a detached signature endpoint is configured.
+type SignatureConfig struct {
+	PublicKeys     []string        `yaml:"public_keys,omitempty"`
+	PublicKeyFiles []string        `yaml:"public_key_files,omitempty"`
+	Endpoint       *EndpointConfig `yaml:"endpoint,omitempty"`
+}
+
+// TLSConfig configures the TLS connections to the API. Certificates and keys are either file paths
+// or PEM encoded contents, the latter can be read from K8s secrets through config variables.
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file,omitempty"`
+	CA                 string `yaml:"ca,omitempty"`
+	CertFile           string `yaml:"cert_file,omitempty"`
+	Cert               string `yaml:"cert,omitempty"`
+	KeyFile            string `yaml:"key_file,omitempty"`
+	Key                string `yaml:"key,omitempty"`
+	ServerName         string `yaml:"server_name,omitempty"`
+	MinVersion         string `yaml:"min_version,omitempty"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify,omitempty"`
+}
+
+type RetryConfig struct {
+	MaxAttempts    int           `yaml:"max_attempts,omitempty"`
+	InitialBackoff time.Duration `yaml:"initial_backoff,omitempty"`
+	MaxBackoff     time.Duration `yaml:"max_backoff,omitempty"`
+	RetryOnStatus  []int         `yaml:"retry_on_status,omitempty"`
+}
+
+// RegistryConfig configures an OCI distribution (Docker) registry as the source of the latest
+// tags instead of the versioning API.
+type RegistryConfig struct {
+	URL             string        `yaml:"url"`
+	Username        string        `yaml:"username,omitempty"`
+	Password        string        `yaml:"password,omitempty"`
+	ImagePrefix     string        `yaml:"image_prefix,omitempty"`
+	TagPattern      string        `yaml:"tag_pattern,omitempty"`
+	Constraint      string        `yaml:"constraint,omitempty"`
+	AllowPrerelease bool          `yaml:"allow_prerelease,omitempty"`
+	Timeout         time.Duration `yaml:"timeout,omitempty"`
 }
 
 type LogConfig struct {
@@ -39,9 +134,39 @@ type EndpointConfig struct {
 	Params   *ParamConf `yaml:"params,omitempty"`
 }
 
+// APIAuth configures how the API requests are authorized. Exactly one of the static header
+// (header_key and header_value), bearer, basic, oauth2 and hmac methods must be configured.
 type APIAuth struct {
-	HeaderKey   string `yaml:"header_key"`
-	HeaderValue string `yaml:"header_value"`
+	HeaderKey   string      `yaml:"header_key,omitempty"`
+	HeaderValue string      `yaml:"header_value,omitempty"`
+	Bearer      *BearerAuth `yaml:"bearer,omitempty"`
+	Basic       *BasicAuth  `yaml:"basic,omitempty"`
+	OAuth2      *OAuth2Auth `yaml:"oauth2,omitempty"`
+	HMAC        *HMACAuth   `yaml:"hmac,omitempty"`
+}
+
+type BearerAuth struct {
+	Token     string `yaml:"token,omitempty"`
+	TokenFile string `yaml:"token_file,omitempty"`
+}
+
+type BasicAuth struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+type OAuth2Auth struct {
+	TokenURL       string            `yaml:"token_url"`
+	ClientID       string            `yaml:"client_id"`
+	ClientSecret   string            `yaml:"client_secret"`
+	Scopes         []string          `yaml:"scopes,omitempty"`
+	EndpointParams map[string]string `yaml:"endpoint_params,omitempty"`
+}
+
+type HMACAuth struct {
+	Secret          string `yaml:"secret"`
+	Header          string `yaml:"header,omitempty"`
+	TimestampHeader string `yaml:"timestamp_header,omitempty"`
 }
 
 type ParamConf struct {
@@ -72,14 +197,114 @@ const (
 )
 
 type LatestTagResponse struct {
-	Tag   string `json:"tag"`
-	Image string `json:"image"`
-	URL   string `json:"url"`
+	Tag    string `json:"tag"`
+	Image  string `json:"image"`
+	URL    string `json:"url"`
+	Digest string `json:"digest,omitempty"`
+	// Signature is the base64 encoded signature of the canonical payload of the response, see
+	// SignaturePayload.
+	Signature string `json:"signature,omitempty"`
+	// Changes are applied together with the image in addition to the entity's configured changes.
+	// They can only target the resources of the entity's paths.
+	Changes []ValueChange `json:"changes,omitempty"`
+}
+
+// UpdateStrategy defines how the changed values are written to the K8s resources.
+type UpdateStrategy string
+
+const (
+	// UpdateStrategyUpdate replaces the whole resource with an update request
+	UpdateStrategyUpdate UpdateStrategy = "update"
+	// UpdateStrategyPatch sends a JSON patch of the changed fields only
+	UpdateStrategyPatch UpdateStrategy = "patch"
+	// UpdateStrategyApply sends a server-side apply of the changed fields only, so that the updater
+	// owns them as a field manager
+	UpdateStrategyApply UpdateStrategy = "apply"
+
+	DefaultFieldManager = "edgedelta-updater"
+)
+
+// K8sConfig configures how the K8s resources are written.
+type K8sConfig struct {
+	// UpdateStrategy defaults to UpdateStrategyUpdate
+	UpdateStrategy UpdateStrategy `yaml:"update_strategy,omitempty"`
+	// FieldManager is the field manager name of the writes, defaults to DefaultFieldManager
+	FieldManager string `yaml:"field_manager,omitempty"`
+	// ForceConflicts makes the server-side applies take over the fields owned by other field
+	// managers instead of failing
+	ForceConflicts bool `yaml:"force_conflicts,omitempty"`
+	// DisableAudit turns off the audit annotations and Events of the updated resources
+	DisableAudit bool `yaml:"disable_audit,omitempty"`
+}
+
+// LeaderElectionConfig configures the coordination.k8s.io Lease held by the updater instance
+// which updates the resources. The zero values are replaced with the k8s package's defaults.
+type LeaderElectionConfig struct {
+	LeaseName      string `yaml:"lease_name,omitempty"`
+	LeaseNamespace string `yaml:"lease_namespace,omitempty"`
+	// Identity of the instance in the lease, defaults to the host name with a random suffix
+	Identity      string        `yaml:"identity,omitempty"`
+	LeaseDuration time.Duration `yaml:"lease_duration,omitempty"`
+	RenewDeadline time.Duration `yaml:"renew_deadline,omitempty"`
+	RetryPeriod   time.Duration `yaml:"retry_period,omitempty"`
+}
+
+type DryRunMode string
+
+const (
+	DryRunNone   DryRunMode = "none"
+	DryRunClient DryRunMode = "client"
+	DryRunServer DryRunMode = "server"
+)
+
+type ChangeAction string
+
+const (
+	ChangeUpdate     ChangeAction = "update"
+	ChangeUnchanged  ChangeAction = "unchanged"
+	ChangeSkip       ChangeAction = "skip"
+	ChangeFailed     ChangeAction = "failed"
+	ChangeRolledBack ChangeAction = "rolled_back"
+	ChangeRejected   ChangeAction = "rejected"
+)
+
+// PathChange is the outcome of updating a single K8s resource path of an entity.
+type PathChange struct {
+	EntityID string          `json:"entity_id"`
+	Path     K8sResourcePath `json:"path"`
+	Current  string          `json:"current"`
+	Target   string          `json:"target"`
+	Action   ChangeAction    `json:"action"`
+	Error    string          `json:"error,omitempty"`
+	// CurrentValue is the unstructured value of Current, used to roll the path back
+	CurrentValue any `json:"-"`
+	// RolloutDuration is how long the rollout of the path's K8s object was waited for
+	RolloutDuration time.Duration `json:"-"`
+}
+
+// UpdateReport is the outcome of an entity's update, sent to the report endpoint of the API after
+// each run. Tag is empty if no latest applicable tag could be fetched.
+type UpdateReport struct {
+	EntityID string       `json:"entity_id"`
+	Image    string       `json:"image"`
+	Tag      string       `json:"tag,omitempty"`
+	Paths    []PathReport `json:"paths"`
+}
+
+// PathReport is the outcome of a single path of an entity's update.
+type PathReport struct {
+	Path   K8sResourcePath `json:"path"`
+	Old    string          `json:"old"`
+	New    string          `json:"new"`
+	Status ChangeAction    `json:"status"`
+	Error  string          `json:"error,omitempty"`
+	// RolloutDurationSeconds is zero if the rollout was not waited for
+	RolloutDurationSeconds float64 `json:"rollout_duration_seconds,omitempty"`
 }
 
 type VersioningServiceClient interface {
-	GetLatestApplicableTag(entityID, entityName string) (*LatestTagResponse, error)
-	GetPresignedLogUploadURL(logSize int) (string, error)
-	UploadLogs(lines []any) error
-	GetMetadata() (map[string]string, error)
+	GetLatestApplicableTag(ctx context.Context, entityID, entityName string) (*LatestTagResponse, error)
+	GetPresignedLogUploadURL(ctx context.Context, logSize int) (string, error)
+	UploadLogs(ctx context.Context, lines []any) error
+	GetMetadata(ctx context.Context) (map[string]string, error)
 }
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	goyaml "github.com/go-yaml/yaml"
+	"k8s.io/apimachinery/pkg/api/resource"
+	utiljson "k8s.io/apimachinery/pkg/util/json"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	ctxVarRe = regexp.MustCompile(`{{\s*\.ctx\.([^{} ]+)\s*}}`)
+)
+
+// ValueType is the type of a value set at a key path.
+type ValueType string
+
+const (
+	// ValueAuto infers the type from the YAML representation of the value, e.g. "3" is an integer,
+	// "true" a boolean and "{name: A, value: B}" an object
+	ValueAuto     ValueType = ""
+	ValueString   ValueType = "string"
+	ValueInt      ValueType = "int"
+	ValueBool     ValueType = "bool"
+	ValueQuantity ValueType = "quantity"
+	// ValueObject is a YAML or JSON object or list, e.g. an env var entry or an annotation map
+	ValueObject ValueType = "object"
+)
+
+// ParseValue parses the raw value as the given type and returns it in its unstructured form, i.e.
+// as a string, int64, float64, bool, map[string]any or []any.
+func ParseValue(raw string, typ ValueType) (any, error) {
+	switch typ {
+	case ValueString:
+		return raw, nil
+	case ValueInt:
+		v, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not an integer", raw)
+		}
+		return v, nil
+	case ValueBool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not a boolean", raw)
+		}
+		return v, nil
+	case ValueQuantity:
+		// Quantities are always serialized as strings by the API server
+		q, err := resource.ParseQuantity(raw)
+		if err != nil {
+			return nil, fmt.Errorf("value %q is not a quantity, err: %v", raw, err)
+		}
+		return q.String(), nil
+	case ValueAuto, ValueObject:
+		v, err := parseYAML(raw)
+		if err != nil {
+			return nil, err
+		}
+		if typ == ValueObject {
+			switch v.(type) {
+			case map[string]any, []any:
+			default:
+				return nil, fmt.Errorf("value %q is not an object or a list", raw)
+			}
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unknown value type %q", typ)
+}
+
+func parseYAML(raw string) (any, error) {
+	b, err := yaml.YAMLToJSON([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("yaml.YAMLToJSON: %v", err)
+	}
+	// Unlike encoding/json, utiljson decodes whole numbers as int64 as in unstructured objects
+	var v any
+	if err := utiljson.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	return v, nil
+}
+
+// FormatValue returns the textual representation of an unstructured value: strings as they are,
+// nil as an empty string and the others as compact JSON.
+func FormatValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(b)
+}
+
+// Evaluate returns the unstructured value of the change. String values are evaluated as templates
+// with the given contextual variables first, which are referred to as {{ .ctx.<KEY> }}.
+func (c *ValueChange) Evaluate(vars map[string]string) (any, error) {
+	raw, ok := c.Value.(string)
+	if !ok {
+		if c.Value == nil {
+			return nil, fmt.Errorf("no value is given for path %s", c.Path)
+		}
+		// Non-string values are evaluated through their YAML form, which also turns the maps
+		// decoded from the YAML config into unstructured maps
+		b, err := goyaml.Marshal(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("yaml.Marshal: %v", err)
+		}
+		raw = strings.TrimSuffix(string(b), "\n")
+	}
+	raw, err := EvaluateContextualTemplate(ctxVarRe.ReplaceAllString(raw, `{{ index .Vars "$1" }}`), vars)
+	if err != nil {
+		return nil, fmt.Errorf("core.EvaluateContextualTemplate: %v", err)
+	}
+	typ := c.Type
+	if ok && typ == ValueAuto {
+		typ = ValueString
+	}
+	return ParseValue(raw, typ)
+}
+
+// Validate checks that the change has a valid path, a value and a known type.
+func (c *ValueChange) Validate() error {
+	if _, err := c.Path.Parse(); err != nil {
+		return fmt.Errorf("invalid path %s, err: %v", c.Path, err)
+	}
+	if c.Value == nil {
+		return fmt.Errorf("no value is given for path %s", c.Path)
+	}
+	switch c.Type {
+	case ValueAuto, ValueString, ValueInt, ValueBool, ValueQuantity, ValueObject:
+		return nil
+	}
+	return fmt.Errorf("unknown value type %q for path %s", c.Type, c.Path)
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		raw     string
+		typ     ValueType
+		want    any
+		wantErr bool
+	}{
+		{raw: "3", typ: ValueAuto, want: int64(3)},
+		{raw: "0.5", typ: ValueAuto, want: 0.5},
+		{raw: "true", typ: ValueAuto, want: true},
+		{raw: "gcr.io/my-org/agent:v1.2.3", typ: ValueAuto, want: "gcr.io/my-org/agent:v1.2.3"},
+		{raw: "{name: AGENT_VERSION, value: v1.2.3}", typ: ValueAuto, want: map[string]any{"name": "AGENT_VERSION", "value": "v1.2.3"}},
+		{raw: `["--log-level", "info"]`, typ: ValueObject, want: []any{"--log-level", "info"}},
+		{raw: "3", typ: ValueString, want: "3"},
+		{raw: "3", typ: ValueInt, want: int64(3)},
+		{raw: "false", typ: ValueBool, want: false},
+		{raw: "0.5Gi", typ: ValueQuantity, want: "512Mi"},
+		{raw: "three", typ: ValueInt, wantErr: true},
+		{raw: "yes please", typ: ValueBool, wantErr: true},
+		{raw: "lots", typ: ValueQuantity, wantErr: true},
+		{raw: "3", typ: ValueObject, wantErr: true},
+		{raw: "{a: [}", typ: ValueAuto, wantErr: true},
+		{raw: "3", typ: "float", wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(string(tc.typ)+"_"+tc.raw, func(t *testing.T) {
+			got, err := ParseValue(tc.raw, tc.typ)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Wanted an error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Value mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value any
+		want  string
+	}{
+		{value: nil, want: ""},
+		{value: "gcr.io/my-org/agent:v1.2.3", want: "gcr.io/my-org/agent:v1.2.3"},
+		{value: int64(3), want: "3"},
+		{value: map[string]any{"name": "AGENT_VERSION", "value": "v1.2.3"}, want: `{"name":"AGENT_VERSION","value":"v1.2.3"}`},
+	}
+	for _, tc := range tests {
+		if got := FormatValue(tc.value); got != tc.want {
+			t.Errorf("Wanted %s, got %s instead", tc.want, got)
+		}
+	}
+}
+
+func TestValueChangeEvaluate(t *testing.T) {
+	vars := map[string]string{"tag": "v1.2.3", "image": "gcr.io/my-org/agent:v1.2.3"}
+	tests := []struct {
+		desc    string
+		change  ValueChange
+		want    any
+		wantErr bool
+	}{
+		{
+			desc:   "String template",
+			change: ValueChange{Value: "{{ .ctx.tag }}"},
+			want:   "v1.2.3",
+		},
+		{
+			desc:   "Converted contextual variable",
+			change: ValueChange{Value: `{{ index .Vars "image" }}`},
+			want:   "gcr.io/my-org/agent:v1.2.3",
+		},
+		{
+			desc:   "String is not inferred",
+			change: ValueChange{Value: "3"},
+			want:   "3",
+		},
+		{
+			desc:   "Typed string",
+			change: ValueChange{Value: "3", Type: ValueInt},
+			want:   int64(3),
+		},
+		{
+			desc:   "Number",
+			change: ValueChange{Value: 2},
+			want:   int64(2),
+		},
+		{
+			desc:   "Number as a string",
+			change: ValueChange{Value: 2, Type: ValueString},
+			want:   "2",
+		},
+		{
+			desc:   "Object decoded from the config",
+			change: ValueChange{Value: map[interface{}]interface{}{"name": "AGENT_VERSION", "value": "{{ .ctx.tag }}"}},
+			want:   map[string]any{"name": "AGENT_VERSION", "value": "v1.2.3"},
+		},
+		{
+			desc:    "Missing value",
+			change:  ValueChange{},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := tc.change.Evaluate(vars)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Wanted an error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Wanted no error, got %v instead", err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Evaluate() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
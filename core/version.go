@@ -0,0 +1,18 @@
+package core
+
+import "runtime/debug"
+
+// Version is the version of the updater, which can be set at build time with
+// -ldflags "-X github.com/edgedelta/updater/core.Version=<VERSION>". It defaults to the module
+// version of the build, "dev" for local builds.
+var Version = ""
+
+func init() {
+	if Version != "" {
+		return
+	}
+	Version = "dev"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		Version = info.Main.Version
+	}
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+type UpdateLevel string
+
+const (
+	UpdatePatch UpdateLevel = "patch"
+	UpdateMinor UpdateLevel = "minor"
+	UpdateMajor UpdateLevel = "major"
+)
+
+// VersionPolicy restricts the tags from the API which can be applied to an entity. Tags must be
+// semantic versions, with or without a "v" prefix.
+type VersionPolicy struct {
+	// Constraint is a semantic version constraint such as "~1.4", "^2" or ">=2.0 <3.0"
+	Constraint string `yaml:"constraint,omitempty"`
+	// MaxUpdate is the largest allowed change compared with the current version: patch, minor or major
+	MaxUpdate       UpdateLevel `yaml:"max_update,omitempty"`
+	AllowPrerelease bool        `yaml:"allow_prerelease,omitempty"`
+	AllowDowngrade  bool        `yaml:"allow_downgrade,omitempty"`
+}
+
+func (p *VersionPolicy) Validate() error {
+	if p.Constraint != "" {
+		if _, err := semver.NewConstraint(p.Constraint); err != nil {
+			return fmt.Errorf("invalid version constraint %q, err: %v", p.Constraint, err)
+		}
+	}
+	switch p.MaxUpdate {
+	case "", UpdatePatch, UpdateMinor, UpdateMajor:
+	default:
+		return fmt.Errorf("invalid max update %q, must be one of patch, minor or major", p.MaxUpdate)
+	}
+	return nil
+}
+
+// CheckTag returns an error if the tag is not allowed regardless of the current version.
+func (p *VersionPolicy) CheckTag(tag string) error {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return fmt.Errorf("tag %q is not a semantic version", tag)
+	}
+	if v.Prerelease() != "" && !p.AllowPrerelease {
+		return fmt.Errorf("tag %q is a pre-release", tag)
+	}
+	if p.Constraint == "" {
+		return nil
+	}
+	c, err := semver.NewConstraint(p.Constraint)
+	if err != nil {
+		return fmt.Errorf("invalid version constraint %q, err: %v", p.Constraint, err)
+	}
+	if ok, errs := c.Validate(v); !ok {
+		reasons := make([]string, 0, len(errs))
+		for _, e := range errs {
+			reasons = append(reasons, e.Error())
+		}
+		return fmt.Errorf("tag %q does not satisfy constraint %q: %s", tag, p.Constraint, strings.Join(reasons, ", "))
+	}
+	return nil
+}
+
+// CheckUpdate returns an error if updating from the current tag to the given tag is not allowed.
+// An empty or non-semantic current tag can not be compared, so any update from it is allowed.
+func (p *VersionPolicy) CheckUpdate(currentTag, tag string) error {
+	if currentTag == "" {
+		return nil
+	}
+	cur, err := semver.NewVersion(currentTag)
+	if err != nil {
+		return nil
+	}
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return fmt.Errorf("tag %q is not a semantic version", tag)
+	}
+	if v.LessThan(cur) && !p.AllowDowngrade {
+		return fmt.Errorf("downgrade from %q to %q is not allowed", currentTag, tag)
+	}
+	switch p.MaxUpdate {
+	case UpdatePatch:
+		if v.Major() != cur.Major() || v.Minor() != cur.Minor() {
+			return fmt.Errorf("update from %q to %q is not a patch update", currentTag, tag)
+		}
+	case UpdateMinor:
+		if v.Major() != cur.Major() {
+			return fmt.Errorf("update from %q to %q is a major update", currentTag, tag)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package core
+
+import "testing"
+
+func TestVersionPolicy(t *testing.T) {
+	tests := []struct {
+		desc       string
+		policy     VersionPolicy
+		currentTag string
+		tag        string
+		wantErr    bool
+	}{
+		{
+			desc:       "Tilde constraint satisfied",
+			policy:     VersionPolicy{Constraint: "~1.4"},
+			currentTag: "v1.4.1",
+			tag:        "v1.4.3",
+		},
+		{
+			desc:       "Tilde constraint not satisfied",
+			policy:     VersionPolicy{Constraint: "~1.4"},
+			currentTag: "v1.4.1",
+			tag:        "v1.5.0",
+			wantErr:    true,
+		},
+		{
+			desc:       "Range constraint satisfied",
+			policy:     VersionPolicy{Constraint: ">=2.0 <3.0"},
+			currentTag: "v2.1.0",
+			tag:        "v2.9.1",
+		},
+		{
+			desc:       "Range constraint not satisfied",
+			policy:     VersionPolicy{Constraint: ">=2.0 <3.0"},
+			currentTag: "v2.1.0",
+			tag:        "v3.0.0",
+			wantErr:    true,
+		},
+		{
+			desc:       "Pre-release rejected",
+			policy:     VersionPolicy{},
+			currentTag: "v2.1.0",
+			tag:        "v2.2.0-rc.1",
+			wantErr:    true,
+		},
+		{
+			desc:       "Pre-release allowed",
+			policy:     VersionPolicy{AllowPrerelease: true},
+			currentTag: "v2.1.0",
+			tag:        "v2.2.0-rc.1",
+		},
+		{
+			desc:       "Downgrade rejected",
+			policy:     VersionPolicy{},
+			currentTag: "v2.1.0",
+			tag:        "v2.0.9",
+			wantErr:    true,
+		},
+		{
+			desc:       "Downgrade allowed",
+			policy:     VersionPolicy{AllowDowngrade: true},
+			currentTag: "v2.1.0",
+			tag:        "v2.0.9",
+		},
+		{
+			desc:       "Patch only satisfied",
+			policy:     VersionPolicy{MaxUpdate: UpdatePatch},
+			currentTag: "v2.1.0",
+			tag:        "v2.1.7",
+		},
+		{
+			desc:       "Patch only not satisfied",
+			policy:     VersionPolicy{MaxUpdate: UpdatePatch},
+			currentTag: "v2.1.0",
+			tag:        "v2.2.0",
+			wantErr:    true,
+		},
+		{
+			desc:       "Minor update not satisfied",
+			policy:     VersionPolicy{MaxUpdate: UpdateMinor},
+			currentTag: "v2.1.0",
+			tag:        "v3.0.0",
+			wantErr:    true,
+		},
+		{
+			desc:       "Non-semantic current tag",
+			policy:     VersionPolicy{MaxUpdate: UpdatePatch},
+			currentTag: "latest",
+			tag:        "v3.0.0",
+		},
+		{
+			desc:    "Non-semantic tag",
+			policy:  VersionPolicy{},
+			tag:     "latest",
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if err := tc.policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			err := tc.policy.CheckTag(tc.tag)
+			if err == nil {
+				err = tc.policy.CheckUpdate(tc.currentTag, tc.tag)
+			}
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+		})
+	}
+}
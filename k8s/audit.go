@@ -0,0 +1,185 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/log"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	AnnotationEntityID       = "updater.edgedelta.com/entity-id"
+	AnnotationTag            = "updater.edgedelta.com/tag"
+	AnnotationPreviousValues = "updater.edgedelta.com/previous-values"
+	AnnotationNewValues      = "updater.edgedelta.com/new-values"
+	AnnotationUpdatedAt      = "updater.edgedelta.com/updated-at"
+	AnnotationVersion        = "updater.edgedelta.com/updater-version"
+
+	EventReasonImageUpdated    = "ImageUpdated"
+	EventReasonImageRolledBack = "ImageRolledBack"
+	EventReasonUpdateFailed    = "UpdateFailed"
+
+	eventComponent = "edgedelta-updater"
+	// maxEventMessageLength is the limit of the API server for the message of an Event
+	maxEventMessageLength = 1024
+)
+
+// Audit describes the origin of a set of changes. It is recorded on each updated object as
+// annotations, in the same request as the changes, and as an Event.
+type Audit struct {
+	EntityID string
+	// Tag is the latest applicable tag of the entity, empty for rollbacks
+	Tag string
+	// RolledBack is set if the changes restore the values before a failed rollout
+	RolledBack bool
+}
+
+// auditAnnotations returns the annotations recording the updated changes of an object. The
+// previous and new values are JSON objects keyed by the key paths of the fields.
+func auditAnnotations(audit *Audit, changes []FieldChange, now time.Time) map[string]string {
+	previous, updated := make(map[string]any), make(map[string]any)
+	for _, ch := range changes {
+		if ch.Updated {
+			keyPath := strings.Join(ch.KeyPath, ".")
+			previous[keyPath], updated[keyPath] = ch.Old, ch.Value
+		}
+	}
+	// Maps of unstructured values can always be marshalled
+	previousJSON, _ := json.Marshal(previous)
+	updatedJSON, _ := json.Marshal(updated)
+	return map[string]string{
+		AnnotationEntityID:       audit.EntityID,
+		AnnotationTag:            audit.Tag,
+		AnnotationPreviousValues: string(previousJSON),
+		AnnotationNewValues:      string(updatedJSON),
+		AnnotationUpdatedAt:      now.UTC().Format(time.RFC3339),
+		AnnotationVersion:        core.Version,
+	}
+}
+
+// setAnnotations sets the given annotations on obj and returns their patches. A missing
+// annotations map is created with a patch of its own, so that the annotations of other field
+// managers are never part of the patches.
+func setAnnotations(obj *unstructured.Unstructured, annotations map[string]string) []fieldPatch {
+	patches := make([]fieldPatch, 0, len(annotations)+1)
+	metadata := obj.Object["metadata"].(map[string]any)
+	existing, ok := metadata["annotations"].(map[string]any)
+	if !ok {
+		existing = make(map[string]any)
+		metadata["annotations"] = existing
+		patches = append(patches, fieldPatch{pointer: []string{"metadata", "annotations"}, value: map[string]any{}})
+	}
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	// Sorted for deterministic patches
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, existed := existing[k]
+		existing[k] = annotations[k]
+		patches = append(patches, fieldPatch{pointer: []string{"metadata", "annotations", k}, existed: existed, value: annotations[k]})
+	}
+	return patches
+}
+
+// auditMessage returns the message of the Event recording the updated changes of an object.
+func auditMessage(audit *Audit, changes []FieldChange) string {
+	updates := make([]string, 0, len(changes))
+	for _, ch := range changes {
+		if ch.Updated {
+			updates = append(updates, fmt.Sprintf("%s from %q to %q", strings.Join(ch.KeyPath, "."), core.FormatValue(ch.Old), core.FormatValue(ch.Value)))
+		}
+	}
+	if audit.RolledBack {
+		return fmt.Sprintf("Rolled back %s for entity %s", strings.Join(updates, ", "), audit.EntityID)
+	}
+	return fmt.Sprintf("Updated %s for entity %s (tag: %s)", strings.Join(updates, ", "), audit.EntityID, audit.Tag)
+}
+
+// RecordEvent records an Event with the given type (corev1.EventTypeNormal or
+// corev1.EventTypeWarning), reason and message for each object targeted by the given path.
+func (c *Client) RecordEvent(ctx context.Context, path core.K8sResourcePath, eventType, reason, message string) error {
+	res, err := path.Parse()
+	if err != nil {
+		return fmt.Errorf("path.Parse: %v", err)
+	}
+	mapping, err := c.resourceMapping(res.Kind)
+	if err != nil {
+		return fmt.Errorf("failed to resolve k8s resource kind %q, err: %v", res.Kind, err)
+	}
+	objs, err := c.objects(ctx, mapping, res)
+	if err != nil {
+		return err
+	}
+	for i := range objs {
+		if objs[i].GetKind() == "" {
+			objs[i].SetGroupVersionKind(mapping.GroupVersionKind)
+		}
+		if err := c.recordEvent(ctx, &objs[i], eventType, reason, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) recordEvent(ctx context.Context, obj *unstructured.Unstructured, eventType, reason, message string) error {
+	namespace := obj.GetNamespace()
+	if namespace == "" {
+		// Events of cluster-scoped objects
+		namespace = v1.NamespaceDefault
+	}
+	if len(message) > maxEventMessageLength {
+		message = message[:maxEventMessageLength-3] + "..."
+	}
+	now := v1.NewTime(time.Now())
+	event := &corev1.Event{
+		ObjectMeta: v1.ObjectMeta{GenerateName: obj.GetName() + ".", Namespace: namespace},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion:      obj.GetAPIVersion(),
+			Kind:            obj.GetKind(),
+			Namespace:       obj.GetNamespace(),
+			Name:            obj.GetName(),
+			UID:             obj.GetUID(),
+			ResourceVersion: obj.GetResourceVersion(),
+		},
+		Type:                eventType,
+		Reason:              reason,
+		Message:             message,
+		Source:              corev1.EventSource{Component: eventComponent},
+		FirstTimestamp:      now,
+		LastTimestamp:       now,
+		Count:               1,
+		ReportingController: eventComponent,
+	}
+	if _, err := c.clientset.CoreV1().Events(namespace).Create(ctx, event, v1.CreateOptions{}); err != nil {
+		return fmt.Errorf("kubernetes.Clientset.CoreV1.Events.Create: %v", err)
+	}
+	return nil
+}
+
+// recordAuditEvent records the Event of an object's changes. Failing to record it does not fail
+// the changes, so the error is only logged.
+func (c *Client) recordAuditEvent(ctx context.Context, obj *unstructured.Unstructured, audit *Audit, changes []FieldChange, failed error) {
+	eventType, reason := corev1.EventTypeNormal, EventReasonImageUpdated
+	message := auditMessage(audit, changes)
+	switch {
+	case failed != nil:
+		eventType, reason = corev1.EventTypeWarning, EventReasonUpdateFailed
+		message = fmt.Sprintf("Failed to update for entity %s, err: %v", audit.EntityID, failed)
+	case audit.RolledBack:
+		reason = EventReasonImageRolledBack
+	}
+	if err := c.recordEvent(ctx, obj, eventType, reason, message); err != nil {
+		log.Warn("Failed to record %s event of %s %s/%s, err: %v", reason, strings.ToLower(obj.GetKind()), obj.GetNamespace(), obj.GetName(), err)
+	}
+}
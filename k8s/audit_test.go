@@ -0,0 +1,61 @@
+package k8s
+
+import (
+	"testing"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAuditAnnotations(t *testing.T) {
+	changes := []FieldChange{
+		{KeyPath: []string{"spec", "template", "spec", "containers[0]", "image"}, Old: "agent:v1", Value: "agent:v2", Updated: true},
+		{KeyPath: []string{"spec", "template", "spec", "containers[1]", "image"}, Old: "agent:v2", Value: "agent:v2"},
+	}
+	got := auditAnnotations(&Audit{EntityID: "111", Tag: "v2"}, changes, time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC))
+	want := map[string]string{
+		AnnotationEntityID:       "111",
+		AnnotationTag:            "v2",
+		AnnotationPreviousValues: `{"spec.template.spec.containers[0].image":"agent:v1"}`,
+		AnnotationNewValues:      `{"spec.template.spec.containers[0].image":"agent:v2"}`,
+		AnnotationUpdatedAt:      "2023-01-02T03:04:05Z",
+		AnnotationVersion:        core.Version,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("auditAnnotations() mismatch (-want +got):\n%s", diff)
+	}
+	if want, got := `Updated spec.template.spec.containers[0].image from "agent:v1" to "agent:v2" for entity 111 (tag: v2)`, auditMessage(&Audit{EntityID: "111", Tag: "v2"}, changes); got != want {
+		t.Errorf("Wanted message %s, got %s instead", want, got)
+	}
+}
+
+func TestSetAnnotations(t *testing.T) {
+	annotations := map[string]string{AnnotationEntityID: "111", AnnotationTag: "v2"}
+
+	obj := unstructuredDaemonset()
+	delete(obj.Object["metadata"].(map[string]any), "annotations")
+	patches := setAnnotations(obj, annotations)
+	if diff := cmp.Diff(map[string]string{AnnotationEntityID: "111", AnnotationTag: "v2"}, obj.GetAnnotations()); diff != "" {
+		t.Errorf("Annotations mismatch (-want +got):\n%s", diff)
+	}
+	got, err := jsonPatch(obj, patches)
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	want := `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/metadata/annotations","value":{}},{"op":"add","path":"/metadata/annotations/updater.edgedelta.com~1entity-id","value":"111"},{"op":"add","path":"/metadata/annotations/updater.edgedelta.com~1tag","value":"v2"}]`
+	if string(got) != want {
+		t.Errorf("Wanted JSON patch %s, got %s instead", want, got)
+	}
+
+	obj = unstructuredDaemonset()
+	obj.SetAnnotations(map[string]string{"other": "value", AnnotationTag: "v1"})
+	got, err = applyPatch(obj, setAnnotations(obj, annotations))
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	want = `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"annotations":{"updater.edgedelta.com/entity-id":"111","updater.edgedelta.com/tag":"v2"},"name":"agent","namespace":"default"}}`
+	if string(got) != want {
+		t.Errorf("Wanted apply configuration %s, got %s instead", want, got)
+	}
+}
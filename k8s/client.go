@@ -4,18 +4,33 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/edgedelta/updater/core"
 	"github.com/edgedelta/updater/log"
 
+	"k8s.io/apimachinery/pkg/api/meta"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/util/retry"
 )
 
 type Client struct {
-	clientset *kubernetes.Clientset
-	config    *rest.Config
+	clientset       kubernetes.Interface
+	dynamic         dynamic.Interface
+	discoveryMapper *restmapper.DeferredDiscoveryRESTMapper
+	mapper          meta.RESTMapper
+	config          *rest.Config
+	update          core.K8sConfig
 }
 
 type NewClientOpt func(*Client)
@@ -26,6 +41,14 @@ func WithConfig(config *rest.Config) NewClientOpt {
 	}
 }
 
+func WithUpdateConfig(conf *core.K8sConfig) NewClientOpt {
+	return func(c *Client) {
+		if conf != nil {
+			c.update = *conf
+		}
+	}
+}
+
 func NewClient(opts ...NewClientOpt) (*Client, error) {
 	cli := &Client{}
 	for _, o := range opts {
@@ -38,95 +61,300 @@ func NewClient(opts ...NewClientOpt) (*Client, error) {
 			return nil, err
 		}
 	}
+	if cli.update.UpdateStrategy == "" {
+		cli.update.UpdateStrategy = core.UpdateStrategyUpdate
+	}
+	if cli.update.FieldManager == "" {
+		cli.update.FieldManager = core.DefaultFieldManager
+	}
 	cli.clientset, err = kubernetes.NewForConfig(cli.config)
 	if err != nil {
 		return nil, err
 	}
+	cli.dynamic, err = dynamic.NewForConfig(cli.config)
+	if err != nil {
+		return nil, err
+	}
+	discoveryCli := memory.NewMemCacheClient(cli.clientset.Discovery())
+	cli.discoveryMapper = restmapper.NewDeferredDiscoveryRESTMapper(discoveryCli)
+	cli.mapper = restmapper.NewShortcutExpander(cli.discoveryMapper, discoveryCli)
 	return cli, nil
 }
 
-func (c *Client) SetResourceKeyValue(ctx context.Context, path core.K8sResourcePath, updateValue string) error {
-	res, err := path.Parse()
-	if err != nil {
-		return fmt.Errorf("path.Parse: %v", err)
-	}
-	if _, ok := core.SupportedK8sResourceKinds[res.Kind]; !ok {
-		return fmt.Errorf("k8s resource kind %q is not supported", res.Kind)
-	}
-	switch res.Kind {
-	case core.K8sDaemonset:
-		ds, err := c.clientset.AppsV1().DaemonSets(res.Namespace).Get(ctx, res.Name, v1.GetOptions{})
+type PathValue struct {
+	Path  core.K8sResourcePath
+	Value any
+}
+
+type objectValues struct {
+	mapping *meta.RESTMapping
+	obj     *unstructured.Unstructured
+	targets []*core.K8sResourceIdentifier
+	values  []any
+}
+
+func (c *Client) SetResourceKeyValue(ctx context.Context, path core.K8sResourcePath, updateValue any, dryRun core.DryRunMode) ([]FieldChange, error) {
+	return c.SetResourceKeyValues(ctx, []PathValue{{Path: path, Value: updateValue}}, dryRun, nil)
+}
+
+// SetResourceKeyValues applies all the values targeting the same resource with a single request,
+// so that either all or none of them are applied.
+func (c *Client) SetResourceKeyValues(ctx context.Context, values []PathValue, dryRun core.DryRunMode, audit *Audit) ([]FieldChange, error) {
+	objects := make(map[string]*objectValues)
+	keys := make([]string, 0)
+	for _, pv := range values {
+		res, err := pv.Path.Parse()
 		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.DaemonSets.Get: %v", err)
+			return nil, fmt.Errorf("path.Parse: %v", err)
 		}
-		if ds == nil {
-			return fmt.Errorf("no DaemonSet exists with name: %q, namespace: %q", res.Name, res.Namespace)
+		mapping, err := c.resourceMapping(res.Kind)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve k8s resource kind %q, err: %v", res.Kind, err)
 		}
-		fieldSelectorPath := strings.Split(res.UpdateKeyPath, ".")
-		old, updated, err := CompareAndUpdateStructField(ds, fieldSelectorPath, updateValue)
+		objs, err := c.objects(ctx, mapping, res)
 		if err != nil {
-			return fmt.Errorf("k8s.CompareAndUpdateStructField: %v", err)
+			return nil, err
 		}
-		log.Info("Current daemonset image version is %s", old)
-		if !updated {
-			log.Info("Passing version update of resource with path %s to %s, older version is the same as the new one", path, updateValue)
-			return nil
+		for i := range objs {
+			if objs[i].GetKind() == "" {
+				// Needed by server-side applies
+				objs[i].SetGroupVersionKind(mapping.GroupVersionKind)
+			}
+			// Paths can refer to the same kind differently, e.g. "ds" and "daemonsets"
+			key := fmt.Sprintf("%s/%s/%s", mapping.Resource, objs[i].GetNamespace(), objs[i].GetName())
+			ov, ok := objects[key]
+			if !ok {
+				ov = &objectValues{mapping: mapping, obj: &objs[i]}
+				objects[key] = ov
+				keys = append(keys, key)
+			}
+			ov.targets = append(ov.targets, c.objectIdentifier(mapping, res, &objs[i]))
+			ov.values = append(ov.values, pv.Value)
 		}
-		_, err = c.clientset.AppsV1().DaemonSets(res.Namespace).Update(ctx, ds, v1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.DaemonSets.Update: %v", err)
+	}
+	changes := make([]FieldChange, 0)
+	for _, key := range keys {
+		changes = append(changes, c.setObjectKeyValues(ctx, objects[key], dryRun, audit)...)
+	}
+	return changes, nil
+}
+
+func (c *Client) setObjectKeyValues(ctx context.Context, ov *objectValues, dryRun core.DryRunMode, audit *Audit) []FieldChange {
+	kind := strings.ToLower(ov.mapping.GroupVersionKind.Kind)
+	object := ov.targets[0].Object()
+	ri := c.resourceInterface(ov.mapping, ov.obj.GetNamespace())
+	if c.update.DisableAudit {
+		audit = nil
+	}
+	var changes []FieldChange
+	var failed error
+	attempt := 0
+	write := func() error {
+		if attempt > 0 {
+			obj, err := ri.Get(ctx, ov.obj.GetName(), v1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("dynamic.ResourceInterface.Get: %v", err)
+			}
+			ov.obj = obj
+			log.Info("Retrying update of %s %s after a conflict", kind, object)
 		}
-		log.Info("Updated version of resource with path %s to %s", path, updateValue)
-	case core.K8sStatefulset:
-		sts, err := c.clientset.AppsV1().StatefulSets(res.Namespace).Get(ctx, res.Name, v1.GetOptions{})
-		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.StatefulSets.Get: %v", err)
+		attempt++
+		var patches []fieldPatch
+		changes, patches, failed = c.compareAndUpdateObject(ov)
+		if failed != nil || !anyUpdated(changes) || dryRun == core.DryRunClient {
+			return nil
+		}
+		if audit != nil {
+			patches = append(patches, setAnnotations(ov.obj, auditAnnotations(audit, changes, time.Now()))...)
 		}
-		if sts == nil {
-			return fmt.Errorf("no StatefulSet exists with name: %q, namespace: %q", res.Name, res.Namespace)
+		return c.writeObject(ctx, ri, ov.obj, patches, dryRun)
+	}
+	var err error
+	if c.update.UpdateStrategy == core.UpdateStrategyApply {
+		// Server-side applies do not depend on the version they were computed from
+		err = write()
+	} else {
+		err = retry.RetryOnConflict(retry.DefaultRetry, write)
+	}
+	if failed == nil && err != nil {
+		failed = fmt.Errorf("%v (object: %s)", err, object)
+	}
+	if audit != nil && dryRun == core.DryRunNone && (failed != nil || anyUpdated(changes)) {
+		c.recordAuditEvent(ctx, ov.obj, audit, changes, failed)
+	}
+	for _, ch := range changes {
+		log.Info("Current %s value is %s (path: %s)", kind, core.FormatValue(ch.Old), ch.Path)
+	}
+	if failed != nil {
+		// The object is not updated at all, the already set fields are discarded with it
+		return failChanges(changes, failed)
+	}
+	if !anyUpdated(changes) {
+		for _, ch := range changes {
+			log.Info("Passing version update of resource with path %s to %s, older version is the same as the new one", ch.Path, core.FormatValue(ch.Value))
 		}
-		fieldSelectorPath := strings.Split(res.UpdateKeyPath, ".")
-		old, updated, err := CompareAndUpdateStructField(sts, fieldSelectorPath, updateValue)
+		return changes
+	}
+	switch dryRun {
+	case core.DryRunClient:
+		logUpdatedChanges(changes, "Would update version of resource with path %s to %s (dry run)")
+	case core.DryRunServer:
+		logUpdatedChanges(changes, "Would update version of resource with path %s to %s (server dry run)")
+	default:
+		logUpdatedChanges(changes, "Updated version of resource with path %s to %s")
+	}
+	return changes
+}
+
+func (c *Client) compareAndUpdateObject(ov *objectValues) ([]FieldChange, []fieldPatch, error) {
+	changes := make([]FieldChange, 0, len(ov.values))
+	patches := make([]fieldPatch, 0)
+	var failed error
+	for i, target := range ov.targets {
+		cs, fields, err := compareAndUpdateUnstructuredFields(ov.obj.Object, target.KeyPath, ov.values[i])
 		if err != nil {
-			return fmt.Errorf("k8s.CompareAndUpdateStructField: %v", err)
+			if failed == nil {
+				failed = fmt.Errorf("k8s.CompareAndUpdateStructFields (path: %s): %v", target.Path(target.KeyPath), err)
+			}
+			changes = append(changes, FieldChange{Path: target.Path(target.KeyPath), KeyPath: target.KeyPath, Value: ov.values[i]})
+			continue
 		}
-		log.Info("Current statefulset image version is %s", old)
-		if !updated {
-			log.Info("Passing version update of resource with path %s to %s, older version is the same as the new one", path, updateValue)
-			return nil
+		for j := range cs {
+			cs[j].Path = target.Path(cs[j].KeyPath)
+			cs[j].Value = ov.values[i]
+			// The API server removes the fields the field manager applied before but leaves out of
+			// an apply configuration, so it keeps the unchanged fields too
+			if cs[j].Updated || c.update.UpdateStrategy == core.UpdateStrategyApply {
+				patches = append(patches, fieldPatch{pointer: fields[j].pointer, appended: fields[j].appended, existed: cs[j].Old != nil, value: ov.values[i]})
+			}
 		}
-		_, err = c.clientset.AppsV1().StatefulSets(res.Namespace).Update(ctx, sts, v1.UpdateOptions{})
-		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.StatefulSets.Update: %v", err)
+		changes = append(changes, cs...)
+	}
+	return changes, patches, failed
+}
+
+func (c *Client) writeObject(ctx context.Context, ri dynamic.ResourceInterface, obj *unstructured.Unstructured, patches []fieldPatch, dryRun core.DryRunMode) error {
+	var dryRunOpt []string
+	if dryRun == core.DryRunServer {
+		dryRunOpt = []string{v1.DryRunAll}
+	}
+	var patchType types.PatchType
+	var data []byte
+	var err error
+	switch c.update.UpdateStrategy {
+	case core.UpdateStrategyPatch:
+		patchType = types.JSONPatchType
+		data, err = jsonPatch(obj, patches)
+	case core.UpdateStrategyApply:
+		patchType = types.ApplyPatchType
+		data, err = applyPatch(obj, patches)
+	default:
+		// Errors are wrapped so that conflicts are detected by retry.RetryOnConflict
+		if _, err := ri.Update(ctx, obj, v1.UpdateOptions{DryRun: dryRunOpt, FieldManager: c.update.FieldManager}); err != nil {
+			return fmt.Errorf("dynamic.ResourceInterface.Update: %w", err)
 		}
-		log.Info("Updated version of resource with path %s to %s", path, updateValue)
-	case core.K8sDeployment:
-		deploy, err := c.clientset.AppsV1().Deployments(res.Namespace).Get(ctx, res.Name, v1.GetOptions{})
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	opts := v1.PatchOptions{DryRun: dryRunOpt, FieldManager: c.update.FieldManager}
+	if patchType == types.ApplyPatchType {
+		opts.Force = &c.update.ForceConflicts
+	}
+	if _, err := ri.Patch(ctx, obj.GetName(), patchType, data, opts); err != nil {
+		return fmt.Errorf("dynamic.ResourceInterface.Patch: %w", err)
+	}
+	return nil
+}
+
+func failChanges(changes []FieldChange, err error) []FieldChange {
+	for i := range changes {
+		changes[i].Updated = false
+		changes[i].Err = err
+	}
+	return changes
+}
+
+func logUpdatedChanges(changes []FieldChange, format string) {
+	for _, ch := range changes {
+		if ch.Updated {
+			log.Info(format, ch.Path, core.FormatValue(ch.Value))
+		}
+	}
+}
+
+func (c *Client) GetResourceKeyValues(ctx context.Context, path core.K8sResourcePath) ([]FieldValue, error) {
+	res, err := path.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("path.Parse: %v", err)
+	}
+	mapping, err := c.resourceMapping(res.Kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve k8s resource kind %q, err: %v", res.Kind, err)
+	}
+	objs, err := c.objects(ctx, mapping, res)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]FieldValue, 0)
+	for i := range objs {
+		target := c.objectIdentifier(mapping, res, &objs[i])
+		vs, err := GetStructFields(objs[i].Object, res.KeyPath)
 		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.Deployments.Get: %v", err)
+			return nil, fmt.Errorf("k8s.GetStructFields (object: %s): %v", target.Object(), err)
 		}
-		if deploy == nil {
-			return fmt.Errorf("no Deployment exists with name: %q, namespace: %q", res.Name, res.Namespace)
+		for j := range vs {
+			vs[j].Path = target.Path(vs[j].KeyPath)
 		}
-		fieldSelectorPath := strings.Split(res.UpdateKeyPath, ".")
-		old, updated, err := CompareAndUpdateStructField(deploy, fieldSelectorPath, updateValue)
+		values = append(values, vs...)
+	}
+	return values, nil
+}
+
+func (c *Client) objects(ctx context.Context, mapping *meta.RESTMapping, res *core.K8sResourceIdentifier) ([]unstructured.Unstructured, error) {
+	if !res.Multi() {
+		obj, err := c.resourceInterface(mapping, res.Namespace).Get(ctx, res.Name, v1.GetOptions{})
 		if err != nil {
-			return fmt.Errorf("k8s.CompareAndUpdateStructField: %v", err)
-		}
-		log.Info("Current deployment image version is %s", old)
-		if !updated {
-			log.Info("Passing version update of resource with path %s to %s, older version is the same as the new one", path, updateValue)
-			return nil
+			return nil, fmt.Errorf("dynamic.ResourceInterface.Get: %v", err)
 		}
-		_, err = c.clientset.AppsV1().Deployments(res.Namespace).Update(ctx, deploy, v1.UpdateOptions{})
+		return []unstructured.Unstructured{*obj}, nil
+	}
+	namespace := res.Namespace
+	if namespace == core.AllNamespaces {
+		namespace = v1.NamespaceAll
+	}
+	opts := v1.ListOptions{}
+	if res.Selector != "" {
+		selector, err := labels.Parse(res.Selector)
 		if err != nil {
-			return fmt.Errorf("clientset.AppsV1.Deployments.Update: %v", err)
+			return nil, fmt.Errorf("invalid label selector %q, err: %v", res.Selector, err)
 		}
-		log.Info("Updated version of resource with path %s to %s", path, updateValue)
-	default:
-		return fmt.Errorf("unsupported K8s resource kind: %q", res.Kind)
+		opts.LabelSelector = selector.String()
+	} else {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", res.Name).String()
 	}
-	return nil
+	list, err := c.resourceInterface(mapping, namespace).List(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("dynamic.ResourceInterface.List: %v", err)
+	}
+	if len(list.Items) == 0 {
+		return nil, fmt.Errorf("no %s matches %s", strings.ToLower(mapping.GroupVersionKind.Kind), res.Object())
+	}
+	return list.Items, nil
+}
+
+func (c *Client) objectIdentifier(mapping *meta.RESTMapping, res *core.K8sResourceIdentifier, obj *unstructured.Unstructured) *core.K8sResourceIdentifier {
+	if !res.Multi() {
+		return res
+	}
+	namespace := obj.GetNamespace()
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		// The namespace of a cluster-scoped resource's path is ignored, but must not be the
+		// all namespaces wildcard to address the object on its own
+		namespace = ""
+	}
+	return res.ForObject(namespace, obj.GetName())
 }
 
 func (c *Client) GetSecret(ctx context.Context, namespace, name string) (string, error) {
@@ -136,3 +364,44 @@ func (c *Client) GetSecret(ctx context.Context, namespace, name string) (string,
 	}
 	return string(sc.Data[name]), nil
 }
+
+func (c *Client) Ping(ctx context.Context) error {
+	if err := c.clientset.Discovery().RESTClient().Get().AbsPath("/version").Do(ctx).Error(); err != nil {
+		return fmt.Errorf("failed to get the version of the K8s API server, err: %v", err)
+	}
+	return nil
+}
+
+func (c *Client) resourceMapping(kind core.K8sResourceKind) (*meta.RESTMapping, error) {
+	mapping, err := c.mapResource(kind)
+	if meta.IsNoMatchError(err) {
+		// Discovery results are cached, the resource might have been registered after the
+		// cache was populated (e.g. a newly installed CRD)
+		c.discoveryMapper.Reset()
+		mapping, err = c.mapResource(kind)
+	}
+	return mapping, err
+}
+
+func (c *Client) mapResource(kind core.K8sResourceKind) (*meta.RESTMapping, error) {
+	fullySpecifiedGVR, gr := schema.ParseResourceArg(strings.ToLower(string(kind)))
+	var gvk schema.GroupVersionKind
+	var err error
+	if fullySpecifiedGVR != nil {
+		gvk, err = c.mapper.KindFor(*fullySpecifiedGVR)
+	}
+	if gvk.Empty() {
+		gvk, err = c.mapper.KindFor(gr.WithVersion(""))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+}
+
+func (c *Client) resourceInterface(mapping *meta.RESTMapping, namespace string) dynamic.ResourceInterface {
+	if mapping.Scope.Name() == meta.RESTScopeNameRoot {
+		return c.dynamic.Resource(mapping.Resource)
+	}
+	return c.dynamic.Resource(mapping.Resource).Namespace(namespace)
+}
@@ -0,0 +1,196 @@
+package k8s
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/log"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	DefaultLeaseName      = "edgedelta-updater"
+	DefaultLeaseNamespace = "default"
+	DefaultLeaseDuration  = 15 * time.Second
+	DefaultRenewDeadline  = 10 * time.Second
+	DefaultRetryPeriod    = 2 * time.Second
+
+	serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// RunAsLeader blocks and calls fn whenever this instance acquires the leader election lease, until
+// ctx is done. The context of fn is canceled once the lease is lost, after which the instance
+// campaigns for the lease again. The lease is released when ctx is done.
+func (c *Client) RunAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) error {
+	conf, err := leaderElectionDefaults(conf)
+	if err != nil {
+		return err
+	}
+	for ctx.Err() == nil {
+		le, err := c.leaderElector(conf, fn)
+		if err != nil {
+			return err
+		}
+		le.Run(ctx)
+	}
+	return nil
+}
+
+// RunOnceAsLeader calls fn once if this instance acquires the leader election lease within the
+// lease duration and reports whether it did, so that one-shot runs do not overlap with each other
+// or with a daemon. The lease is released once fn returns.
+func (c *Client) RunOnceAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) (bool, error) {
+	conf, err := leaderElectionDefaults(conf)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Once the lease is acquired, fn either starts or the run is abandoned, whichever happens
+	// first, so that fn never starts after the run is reported as skipped
+	var mu sync.Mutex
+	started, abandoned := false, false
+	abandon := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if !started {
+			abandoned = true
+		}
+		return abandoned
+	}
+	done := make(chan struct{})
+	le, err := c.leaderElector(conf, func(ctx context.Context) {
+		mu.Lock()
+		if abandoned {
+			mu.Unlock()
+			return
+		}
+		started = true
+		mu.Unlock()
+		defer close(done)
+		fn(ctx)
+		cancel()
+	})
+	if err != nil {
+		return false, err
+	}
+	timer := time.AfterFunc(conf.LeaseDuration, func() {
+		if abandon() {
+			cancel()
+		}
+	})
+	defer timer.Stop()
+	le.Run(ctx)
+	if abandon() {
+		return false, nil
+	}
+	<-done
+	return true, nil
+}
+
+func (c *Client) leaderElector(conf *core.LeaderElectionConfig, fn func(context.Context)) (*leaderelection.LeaderElector, error) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  v1.ObjectMeta{Name: conf.LeaseName, Namespace: conf.LeaseNamespace},
+		Client:     c.clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: conf.Identity},
+	}
+	// OnStoppedLeading is called even if the lease has never been acquired
+	var leading atomic.Bool
+	le, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   conf.LeaseDuration,
+		RenewDeadline:   conf.RenewDeadline,
+		RetryPeriod:     conf.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            conf.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				leading.Store(true)
+				log.Info("Acquired lease %s/%s as %s", conf.LeaseNamespace, conf.LeaseName, conf.Identity)
+				fn(ctx)
+			},
+			OnStoppedLeading: func() {
+				if leading.Swap(false) {
+					log.Info("Stopped leading lease %s/%s", conf.LeaseNamespace, conf.LeaseName)
+				}
+			},
+			OnNewLeader: func(identity string) {
+				if identity != conf.Identity {
+					log.Info("Lease %s/%s is held by %s, standing by", conf.LeaseNamespace, conf.LeaseName, identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("leaderelection.NewLeaderElector: %v", err)
+	}
+	return le, nil
+}
+
+// ValidateLeaderElectionConfig returns an error if the durations of conf, or their defaults, are not
+// accepted by the leader election: the lease duration must be greater than the renew deadline,
+// which must be greater than the retry period with its jitter.
+func ValidateLeaderElectionConfig(conf *core.LeaderElectionConfig) error {
+	c, err := leaderElectionDefaults(conf)
+	if err != nil {
+		return err
+	}
+	if c.LeaseDuration <= c.RenewDeadline {
+		return fmt.Errorf("lease_duration (%s) must be greater than renew_deadline (%s)", c.LeaseDuration, c.RenewDeadline)
+	}
+	if c.RenewDeadline <= time.Duration(leaderelection.JitterFactor*float64(c.RetryPeriod)) {
+		return fmt.Errorf("renew_deadline (%s) must be greater than %.1f times retry_period (%s)", c.RenewDeadline, leaderelection.JitterFactor, c.RetryPeriod)
+	}
+	return nil
+}
+
+// leaderElectionDefaults returns a copy of conf with the defaults in place of its zero values. The
+// lease namespace defaults to the namespace of the pod's service account.
+func leaderElectionDefaults(conf *core.LeaderElectionConfig) (*core.LeaderElectionConfig, error) {
+	c := core.LeaderElectionConfig{}
+	if conf != nil {
+		c = *conf
+	}
+	if c.LeaseName == "" {
+		c.LeaseName = DefaultLeaseName
+	}
+	if c.LeaseNamespace == "" {
+		c.LeaseNamespace = DefaultLeaseNamespace
+		if b, err := os.ReadFile(serviceAccountNamespaceFile); err == nil && len(b) > 0 {
+			c.LeaseNamespace = strings.TrimSpace(string(b))
+		}
+	}
+	if c.Identity == "" {
+		host, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("os.Hostname: %v", err)
+		}
+		// Instances on the same host, e.g. overlapping manual runs, must not share an identity
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, fmt.Errorf("rand.Read: %v", err)
+		}
+		c.Identity = host + "_" + hex.EncodeToString(suffix)
+	}
+	if c.LeaseDuration <= 0 {
+		c.LeaseDuration = DefaultLeaseDuration
+	}
+	if c.RenewDeadline <= 0 {
+		c.RenewDeadline = DefaultRenewDeadline
+	}
+	if c.RetryPeriod <= 0 {
+		c.RetryPeriod = DefaultRetryPeriod
+	}
+	return &c, nil
+}
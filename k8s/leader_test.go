@@ -0,0 +1,132 @@
+package k8s
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLeaderElectionDefaults(t *testing.T) {
+	got, err := leaderElectionDefaults(&core.LeaderElectionConfig{LeaseNamespace: "monitoring", LeaseDuration: time.Minute})
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	if got.LeaseName != DefaultLeaseName || got.LeaseNamespace != "monitoring" {
+		t.Errorf("Wanted lease %s/%s, got %s/%s instead", "monitoring", DefaultLeaseName, got.LeaseNamespace, got.LeaseName)
+	}
+	if got.LeaseDuration != time.Minute || got.RenewDeadline != DefaultRenewDeadline || got.RetryPeriod != DefaultRetryPeriod {
+		t.Errorf("Wanted durations %s, %s and %s, got %s, %s and %s instead", time.Minute, DefaultRenewDeadline, DefaultRetryPeriod, got.LeaseDuration, got.RenewDeadline, got.RetryPeriod)
+	}
+	other, err := leaderElectionDefaults(nil)
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	if got.Identity == other.Identity || !strings.Contains(got.Identity, "_") {
+		t.Errorf("Wanted distinct host based identities, got %s and %s instead", got.Identity, other.Identity)
+	}
+}
+
+func TestValidateLeaderElectionConfig(t *testing.T) {
+	tests := []struct {
+		desc    string
+		conf    *core.LeaderElectionConfig
+		wantErr bool
+	}{
+		{
+			desc: "Defaults",
+			conf: &core.LeaderElectionConfig{},
+		},
+		{
+			desc:    "Lease duration not greater than the default renew deadline",
+			conf:    &core.LeaderElectionConfig{LeaseDuration: 5 * time.Second},
+			wantErr: true,
+		},
+		{
+			desc:    "Renew deadline not greater than the retry period with jitter",
+			conf:    &core.LeaderElectionConfig{RenewDeadline: 10 * time.Second, RetryPeriod: 9 * time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := ValidateLeaderElectionConfig(tc.conf)
+			if tc.wantErr && err == nil {
+				t.Error("Wanted an error, got nil instead")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Wanted no error, got %v instead", err)
+			}
+		})
+	}
+}
+
+func testLeaderElectionConfig() *core.LeaderElectionConfig {
+	return &core.LeaderElectionConfig{
+		LeaseNamespace: "default",
+		Identity:       "test",
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+}
+
+func TestRunOnceAsLeaderAcquires(t *testing.T) {
+	c := &Client{clientset: fake.NewSimpleClientset()}
+	var calls atomic.Int32
+	ran, err := c.RunOnceAsLeader(context.Background(), testLeaderElectionConfig(), func(ctx context.Context) {
+		if ctx.Err() == nil {
+			calls.Add(1)
+		}
+	})
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	if !ran || calls.Load() != 1 {
+		t.Errorf("Wanted fn to run once with a live context, got ran %t and %d calls instead", ran, calls.Load())
+	}
+}
+
+func TestRunOnceAsLeaderTimesOut(t *testing.T) {
+	cs := fake.NewSimpleClientset()
+	// Another instance keeps renewing the lease
+	cs.PrependReactor("get", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		now := v1.NewMicroTime(time.Now())
+		holder, duration := "other", int32(60)
+		return true, &coordinationv1.Lease{
+			ObjectMeta: v1.ObjectMeta{Name: DefaultLeaseName, Namespace: "default"},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &holder,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, nil
+	})
+	c := &Client{clientset: cs}
+	var calls atomic.Int32
+	start := time.Now()
+	ran, err := c.RunOnceAsLeader(context.Background(), testLeaderElectionConfig(), func(ctx context.Context) {
+		calls.Add(1)
+	})
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Wanted to give up after the lease duration, got %s instead", elapsed)
+	}
+	// fn must not start after the run is reported as skipped
+	time.Sleep(200 * time.Millisecond)
+	if ran || calls.Load() != 0 {
+		t.Errorf("Wanted fn not to run, got ran %t and %d calls instead", ran, calls.Load())
+	}
+}
@@ -0,0 +1,146 @@
+package k8s
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// fieldPatch is an updated field of an object to be written with a patch.
+type fieldPatch struct {
+	// pointer is the JSON pointer tokens of the field, see field
+	pointer  []string
+	appended bool
+	// existed is set if the field had a value before the update
+	existed bool
+	value   any
+}
+
+// patchValue returns the value written at the field's pointer.
+func (p fieldPatch) patchValue() any {
+	if p.appended {
+		return []any{p.value}
+	}
+	return p.value
+}
+
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value any    `json:"value"`
+}
+
+// jsonPatch returns the JSON patch (RFC 6902) of the given fields of obj. The patch sets the
+// resource version obj was read with, so the API server rejects it with a conflict if obj has been
+// modified since then, in which case the indexes of the pointers might be stale.
+func jsonPatch(obj *unstructured.Unstructured, patches []fieldPatch) ([]byte, error) {
+	ops := make([]jsonPatchOperation, 0, len(patches)+1)
+	ops = append(ops, jsonPatchOperation{Op: "replace", Path: "/metadata/resourceVersion", Value: obj.GetResourceVersion()})
+	for _, p := range patches {
+		op := "add"
+		if p.existed {
+			op = "replace"
+		}
+		ops = append(ops, jsonPatchOperation{Op: op, Path: jsonPointer(p.pointer), Value: p.patchValue()})
+	}
+	b, err := json.Marshal(ops)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %v", err)
+	}
+	return b, nil
+}
+
+func jsonPointer(tokens []string) string {
+	var sb strings.Builder
+	for _, t := range tokens {
+		sb.WriteString("/")
+		sb.WriteString(strings.ReplaceAll(strings.ReplaceAll(t, "~", "~0"), "/", "~1"))
+	}
+	return sb.String()
+}
+
+// applyPatch returns the server-side apply configuration of the given fields of obj, i.e. a
+// partial object with only the fields the updater owns. obj must already have the updated values.
+// Slice elements are identified by their "name" field in the configuration, which is the merge key
+// of containers, env vars, volumes and the like, so elements without a name can not be applied.
+func applyPatch(obj *unstructured.Unstructured, patches []fieldPatch) ([]byte, error) {
+	metadata := map[string]any{"name": obj.GetName()}
+	if ns := obj.GetNamespace(); ns != "" {
+		metadata["namespace"] = ns
+	}
+	conf := map[string]any{
+		"apiVersion": obj.GetAPIVersion(),
+		"kind":       obj.GetKind(),
+		"metadata":   metadata,
+	}
+	for _, p := range patches {
+		if err := addApplyField(conf, obj.Object, p.pointer, p.patchValue()); err != nil {
+			return nil, fmt.Errorf("failed to add %s to the apply configuration, err: %v", jsonPointer(p.pointer), err)
+		}
+	}
+	b, err := json.Marshal(conf)
+	if err != nil {
+		return nil, fmt.Errorf("json.Marshal: %v", err)
+	}
+	return b, nil
+}
+
+// addApplyField adds the field at the given pointer of src to the apply configuration dst, along
+// with the names of the slice elements on its way.
+func addApplyField(dst, src map[string]any, pointer []string, value any) error {
+	key := pointer[0]
+	if len(pointer) == 1 {
+		dst[key] = value
+		return nil
+	}
+	switch next := src[key].(type) {
+	case map[string]any:
+		d, ok := dst[key].(map[string]any)
+		if !ok {
+			d = make(map[string]any)
+			dst[key] = d
+		}
+		return addApplyField(d, next, pointer[1:], value)
+	case []any:
+		var elem any
+		if pointer[1] == "-" {
+			elem = value
+		} else {
+			i, err := strconv.Atoi(pointer[1])
+			if err != nil || i < 0 || i >= len(next) {
+				return fmt.Errorf("invalid index %q of '%s'", pointer[1], key)
+			}
+			elem = next[i]
+		}
+		m, ok := elem.(map[string]any)
+		if !ok {
+			return fmt.Errorf("expected an element of '%s' to be an object, got %T instead", key, elem)
+		}
+		name, ok := m["name"].(string)
+		if !ok {
+			return fmt.Errorf("element of '%s' has no name to be applied by", key)
+		}
+		d, _ := dst[key].([]any)
+		j := -1
+		for k, e := range d {
+			if e.(map[string]any)["name"] == name {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			d = append(d, map[string]any{"name": name})
+			j = len(d) - 1
+			dst[key] = d
+		}
+		if len(pointer) == 2 {
+			d[j] = value
+			return nil
+		}
+		return addApplyField(d[j].(map[string]any), m, pointer[2:], value)
+	}
+	return fmt.Errorf("expected '%s' to be an object or a slice, got %T instead", key, src[key])
+}
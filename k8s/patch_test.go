@@ -0,0 +1,167 @@
+package k8s
+
+import (
+	"testing"
+
+	"github.com/edgedelta/updater/core"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPatches(t *testing.T) {
+	tests := []struct {
+		desc          string
+		path          []string
+		value         any
+		wantJSONPatch string
+		wantApply     string
+		wantApplyErr  bool
+	}{
+		{
+			desc:          "Image of a container selected by name",
+			path:          []string{"spec", "template", "spec", "containers[name=agent]", "image"},
+			value:         "gcr.io/my-project/image:v0.1.49",
+			wantJSONPatch: `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"replace","path":"/spec/template/spec/containers/1/image","value":"gcr.io/my-project/image:v0.1.49"}]`,
+			wantApply:     `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"name":"agent","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"image":"gcr.io/my-project/image:v0.1.49","name":"agent"}]}}}}`,
+		},
+		{
+			desc:          "Env var appended to an existing slice",
+			path:          []string{"spec", "template", "spec", "containers[name=sidecar]", "env[name=B]"},
+			value:         map[string]any{"name": "B", "value": "2"},
+			wantJSONPatch: `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/spec/template/spec/containers/0/env/-","value":{"name":"B","value":"2"}}]`,
+			wantApply:     `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"name":"agent","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"env":[{"name":"B","value":"2"}],"name":"sidecar"}]}}}}`,
+		},
+		{
+			desc:          "Env var appended to a missing slice",
+			path:          []string{"spec", "template", "spec", "containers[name=agent]", "env[name=B]"},
+			value:         map[string]any{"name": "B", "value": "2"},
+			wantJSONPatch: `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/spec/template/spec/containers/1/env","value":[{"name":"B","value":"2"}]}]`,
+			wantApply:     `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"name":"agent","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"env":[{"name":"B","value":"2"}],"name":"agent"}]}}}}`,
+		},
+		{
+			desc:          "Annotation with an escaped key",
+			path:          []string{"metadata", "annotations", "example.com/version"},
+			value:         "v0.1.49",
+			wantJSONPatch: `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"add","path":"/metadata/annotations/example.com~1version","value":"v0.1.49"}]`,
+			wantApply:     `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"annotations":{"example.com/version":"v0.1.49"},"name":"agent","namespace":"default"}}`,
+		},
+		{
+			desc:          "Element without a name",
+			path:          []string{"spec", "template", "spec", "containers[name=agent]", "ports[0]", "containerPort"},
+			value:         int64(8081),
+			wantJSONPatch: `[{"op":"replace","path":"/metadata/resourceVersion","value":"42"},{"op":"replace","path":"/spec/template/spec/containers/1/ports/0/containerPort","value":8081}]`,
+			wantApplyErr:  true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			obj := unstructuredDaemonset()
+			changes, fields, err := compareAndUpdateUnstructuredFields(obj.Object, tc.path, tc.value)
+			if err != nil {
+				t.Fatalf("Wanted no error, got %v instead", err)
+			}
+			patches := make([]fieldPatch, 0, len(changes))
+			for i, ch := range changes {
+				patches = append(patches, fieldPatch{pointer: fields[i].pointer, appended: fields[i].appended, existed: ch.Old != nil, value: tc.value})
+			}
+			got, err := jsonPatch(obj, patches)
+			if err != nil {
+				t.Fatalf("Wanted no error, got %v instead", err)
+			}
+			if string(got) != tc.wantJSONPatch {
+				t.Errorf("Wanted JSON patch %s, got %s instead", tc.wantJSONPatch, got)
+			}
+			got, err = applyPatch(obj, patches)
+			if tc.wantApplyErr {
+				if err == nil {
+					t.Errorf("Wanted an apply error, got %s instead", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Wanted no error, got %v instead", err)
+			}
+			if string(got) != tc.wantApply {
+				t.Errorf("Wanted apply configuration %s, got %s instead", tc.wantApply, got)
+			}
+		})
+	}
+}
+
+func TestApplyPatchKeepsUnchangedFields(t *testing.T) {
+	c := &Client{update: core.K8sConfig{UpdateStrategy: core.UpdateStrategyApply}}
+	obj := unstructuredDaemonset()
+	paths := []core.K8sResourcePath{
+		"default:ds/agent:spec.template.spec.containers[name=agent].image",
+		"default:ds/agent:spec.template.spec.containers[name=sidecar].env[name=A].value",
+	}
+	targets := make([]*core.K8sResourceIdentifier, 0, len(paths))
+	for _, p := range paths {
+		ri, err := p.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets = append(targets, ri)
+	}
+	applies := []struct {
+		values []any
+		want   string
+	}{
+		{
+			values: []any{"gcr.io/my-project/image:v0.1.48", "2"},
+			want:   `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"name":"agent","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"image":"gcr.io/my-project/image:v0.1.48","name":"agent"},{"env":[{"name":"A","value":"2"}],"name":"sidecar"}]}}}}`,
+		},
+		{
+			// Only the image changes, the env var must stay in the configuration
+			values: []any{"gcr.io/my-project/image:v0.1.49", "2"},
+			want:   `{"apiVersion":"apps/v1","kind":"DaemonSet","metadata":{"name":"agent","namespace":"default"},"spec":{"template":{"spec":{"containers":[{"image":"gcr.io/my-project/image:v0.1.49","name":"agent"},{"env":[{"name":"A","value":"2"}],"name":"sidecar"}]}}}}`,
+		},
+	}
+	for i, a := range applies {
+		changes, patches, err := c.compareAndUpdateObject(&objectValues{obj: obj, targets: targets, values: a.values})
+		if err != nil {
+			t.Fatalf("Wanted no error, got %v instead", err)
+		}
+		if i == 1 && changes[1].Updated {
+			t.Fatalf("Wanted the env var to be unchanged by apply %d", i+1)
+		}
+		got, err := applyPatch(obj, patches)
+		if err != nil {
+			t.Fatalf("Wanted no error, got %v instead", err)
+		}
+		if string(got) != a.want {
+			t.Errorf("Wanted apply configuration %s of apply %d, got %s instead", a.want, i+1, got)
+		}
+	}
+}
+
+func unstructuredDaemonset() *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]any{
+		"apiVersion": "apps/v1",
+		"kind":       "DaemonSet",
+		"metadata": map[string]any{
+			"name":            "agent",
+			"namespace":       "default",
+			"resourceVersion": "42",
+			"annotations":     map[string]any{},
+		},
+		"spec": map[string]any{
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []any{
+						map[string]any{
+							"name":  "sidecar",
+							"image": "busybox",
+							"env":   []any{map[string]any{"name": "A", "value": "1"}},
+						},
+						map[string]any{
+							"name":  "agent",
+							"image": "gcr.io/my-project/image:v0.1.47",
+							"ports": []any{map[string]any{"containerPort": int64(8080)}},
+						},
+					},
+				},
+			},
+		},
+	}}
+}
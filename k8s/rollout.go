@@ -0,0 +1,286 @@
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/log"
+
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+const (
+	DefaultRolloutTimeout = 5 * time.Minute
+	podCheckInterval      = 10 * time.Second
+)
+
+// rolloutStatusFunc reports whether the rollout of the given object is complete along with a
+// human readable status. A non-nil error means the rollout can not complete anymore.
+type rolloutStatusFunc func(obj *unstructured.Unstructured) (bool, string, error)
+
+var (
+	rolloutStatusFuncs = map[schema.GroupKind]rolloutStatusFunc{
+		{Group: "apps", Kind: "DaemonSet"}:   daemonsetRolloutStatus,
+		{Group: "apps", Kind: "Deployment"}:  deploymentRolloutStatus,
+		{Group: "apps", Kind: "StatefulSet"}: statefulsetRolloutStatus,
+	}
+	// podFailureReasons are the container waiting reasons which mean that a rollout will not
+	// become healthy without an intervention. ErrImagePull is not one of them since the kubelet
+	// reports it on the first failed pull, before backing off.
+	podFailureReasons = map[string]bool{
+		"CrashLoopBackOff":           true,
+		"ImagePullBackOff":           true,
+		"InvalidImageName":           true,
+		"CreateContainerConfigError": true,
+	}
+)
+
+// WaitForRollout watches the resource at the given path until its rollout is complete, or fails
+// if it does not complete within the timeout. DefaultRolloutTimeout is used if timeout is not positive.
+// The rollout fails early if a pod of the resource running updatedImage has a container stuck in one
+// of the podFailureReasons states.
+func (c *Client) WaitForRollout(ctx context.Context, path core.K8sResourcePath, timeout time.Duration, updatedImage string) error {
+	res, err := path.Parse()
+	if err != nil {
+		return fmt.Errorf("path.Parse: %v", err)
+	}
+	if res.Multi() {
+		return fmt.Errorf("path %s does not identify a single resource", path)
+	}
+	mapping, err := c.resourceMapping(res.Kind)
+	if err != nil {
+		return fmt.Errorf("failed to resolve k8s resource kind %q, err: %v", res.Kind, err)
+	}
+	statusFn, ok := rolloutStatusFuncs[mapping.GroupVersionKind.GroupKind()]
+	if !ok {
+		return fmt.Errorf("waiting for rollout is not supported for k8s resource kind %q", mapping.GroupVersionKind.Kind)
+	}
+	if timeout <= 0 {
+		timeout = DefaultRolloutTimeout
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ri := c.resourceInterface(mapping, res.Namespace)
+	obj, err := ri.Get(waitCtx, res.Name, v1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("dynamic.ResourceInterface.Get: %v", err)
+	}
+	selector, err := podSelector(obj)
+	if err != nil {
+		return fmt.Errorf("failed to get pod selector, err: %v", err)
+	}
+	podsCtx, stopPodsCheck := context.WithCancel(waitCtx)
+	podsCheckDone := make(chan struct{})
+	var podsErr error
+	go func() {
+		defer close(podsCheckDone)
+		if podsErr = c.checkPodFailures(podsCtx, res.Namespace, selector, updatedImage); podsErr != nil {
+			cancel()
+		}
+	}()
+
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", res.Name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(opts v1.ListOptions) (runtime.Object, error) {
+			opts.FieldSelector = fieldSelector
+			return ri.List(waitCtx, opts)
+		},
+		WatchFunc: func(opts v1.ListOptions) (watch.Interface, error) {
+			opts.FieldSelector = fieldSelector
+			return ri.Watch(waitCtx, opts)
+		},
+	}
+	var lastStatus string
+	_, err = watchtools.UntilWithSync(waitCtx, lw, &unstructured.Unstructured{}, nil, func(e watch.Event) (bool, error) {
+		switch e.Type {
+		case watch.Deleted:
+			return false, errors.New("resource is deleted while waiting for its rollout")
+		case watch.Added, watch.Modified:
+			obj, ok := e.Object.(*unstructured.Unstructured)
+			if !ok {
+				return false, fmt.Errorf("unexpected object type %T", e.Object)
+			}
+			done, status, err := statusFn(obj)
+			if err != nil {
+				return false, err
+			}
+			if status != lastStatus {
+				log.Info("Rollout status of resource with path %s: %s", path, status)
+				lastStatus = status
+			}
+			return done, nil
+		}
+		return false, nil
+	})
+	stopPodsCheck()
+	<-podsCheckDone
+	if podsErr != nil {
+		return podsErr
+	}
+	if errors.Is(err, wait.ErrWaitTimeout) && ctx.Err() == nil {
+		return fmt.Errorf("rollout did not complete within %s, last status: %q", timeout, lastStatus)
+	}
+	return err
+}
+
+// checkPodFailures periodically checks the pods matching the selector and returns an error as soon
+// as a pod running the given image has a failing container. It returns nil once ctx is done.
+func (c *Client) checkPodFailures(ctx context.Context, namespace string, selector labels.Selector, image string) error {
+	if image == "" || selector == nil || selector.Empty() {
+		return nil
+	}
+	var podsErr error
+	err := wait.PollUntilWithContext(ctx, podCheckInterval, func(ctx context.Context) (bool, error) {
+		pods, err := c.clientset.CoreV1().Pods(namespace).List(ctx, v1.ListOptions{LabelSelector: selector.String()})
+		if err != nil {
+			log.Warn("Failed to list pods with selector %s in namespace %s, err: %v", selector, namespace, err)
+			return false, nil
+		}
+		for i := range pods.Items {
+			if podsErr = podFailure(&pods.Items[i], image); podsErr != nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	if err != nil && !errors.Is(err, wait.ErrWaitTimeout) {
+		return err
+	}
+	return podsErr
+}
+
+func podFailure(pod *corev1.Pod, image string) error {
+	runsImage := false
+	for _, c := range append(pod.Spec.InitContainers, pod.Spec.Containers...) {
+		if c.Image == image {
+			runsImage = true
+			break
+		}
+	}
+	if !runsImage {
+		return nil
+	}
+	for _, cs := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if cs.State.Waiting != nil && podFailureReasons[cs.State.Waiting.Reason] {
+			return fmt.Errorf("container %s of pod %s is in %s state: %s", cs.Name, pod.Name, cs.State.Waiting.Reason, cs.State.Waiting.Message)
+		}
+	}
+	return nil
+}
+
+func podSelector(obj *unstructured.Unstructured) (labels.Selector, error) {
+	m, found, err := unstructured.NestedMap(obj.Object, "spec", "selector")
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, nil
+	}
+	var ls v1.LabelSelector
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, &ls); err != nil {
+		return nil, fmt.Errorf("runtime.UnstructuredConverter.FromUnstructured: %v", err)
+	}
+	return v1.LabelSelectorAsSelector(&ls)
+}
+
+func daemonsetRolloutStatus(obj *unstructured.Unstructured) (bool, string, error) {
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy != "" && strategy != "RollingUpdate" {
+		return false, "", fmt.Errorf("rollout status is only available for RollingUpdate strategy type, got %q", strategy)
+	}
+	if !generationObserved(obj) {
+		return false, "Waiting for daemon set spec update to be observed", nil
+	}
+	desired, _, _ := unstructured.NestedInt64(obj.Object, "status", "desiredNumberScheduled")
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedNumberScheduled")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "numberAvailable")
+	if updated < desired {
+		return false, fmt.Sprintf("Waiting for daemon set rollout to finish: %d out of %d new pods have been updated", updated, desired), nil
+	}
+	if available < desired {
+		return false, fmt.Sprintf("Waiting for daemon set rollout to finish: %d of %d updated pods are available", available, desired), nil
+	}
+	return true, "Daemon set successfully rolled out", nil
+}
+
+func deploymentRolloutStatus(obj *unstructured.Unstructured) (bool, string, error) {
+	if !generationObserved(obj) {
+		return false, "Waiting for deployment spec update to be observed", nil
+	}
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		cond, ok := c.(map[string]any)
+		if !ok {
+			continue
+		}
+		if cond["type"] == "Progressing" && cond["reason"] == "ProgressDeadlineExceeded" {
+			return false, "", errors.New("deployment exceeded its progress deadline")
+		}
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+	total, _, _ := unstructured.NestedInt64(obj.Object, "status", "replicas")
+	available, _, _ := unstructured.NestedInt64(obj.Object, "status", "availableReplicas")
+	if updated < replicas {
+		return false, fmt.Sprintf("Waiting for deployment rollout to finish: %d out of %d new replicas have been updated", updated, replicas), nil
+	}
+	if total > updated {
+		return false, fmt.Sprintf("Waiting for deployment rollout to finish: %d old replicas are pending termination", total-updated), nil
+	}
+	if available < updated {
+		return false, fmt.Sprintf("Waiting for deployment rollout to finish: %d of %d updated replicas are available", available, updated), nil
+	}
+	return true, "Deployment successfully rolled out", nil
+}
+
+func statefulsetRolloutStatus(obj *unstructured.Unstructured) (bool, string, error) {
+	if strategy, _, _ := unstructured.NestedString(obj.Object, "spec", "updateStrategy", "type"); strategy != "" && strategy != "RollingUpdate" {
+		return false, "", fmt.Errorf("rollout status is only available for RollingUpdate strategy type, got %q", strategy)
+	}
+	if observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration"); observed == 0 || !generationObserved(obj) {
+		return false, "Waiting for statefulset spec update to be observed", nil
+	}
+	replicas, found, _ := unstructured.NestedInt64(obj.Object, "spec", "replicas")
+	if !found {
+		replicas = 1
+	}
+	ready, _, _ := unstructured.NestedInt64(obj.Object, "status", "readyReplicas")
+	if ready < replicas {
+		return false, fmt.Sprintf("Waiting for %d pods to be ready", replicas-ready), nil
+	}
+	if partition, found, _ := unstructured.NestedInt64(obj.Object, "spec", "updateStrategy", "rollingUpdate", "partition"); found && partition > 0 {
+		updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+		if updated < replicas-partition {
+			return false, fmt.Sprintf("Waiting for partitioned roll out to finish: %d out of %d new pods have been updated", updated, replicas-partition), nil
+		}
+		return true, fmt.Sprintf("Partitioned roll out complete: %d new pods have been updated", updated), nil
+	}
+	current, _, _ := unstructured.NestedString(obj.Object, "status", "currentRevision")
+	update, _, _ := unstructured.NestedString(obj.Object, "status", "updateRevision")
+	if current != update {
+		updated, _, _ := unstructured.NestedInt64(obj.Object, "status", "updatedReplicas")
+		return false, fmt.Sprintf("Waiting for statefulset rolling update to complete %d pods at revision %s", updated, update), nil
+	}
+	return true, fmt.Sprintf("Statefulset rolling update complete %d pods at revision %s", ready, current), nil
+}
+
+func generationObserved(obj *unstructured.Unstructured) bool {
+	observed, _, _ := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	return obj.GetGeneration() <= observed
+}
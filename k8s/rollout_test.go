@@ -0,0 +1,165 @@
+package k8s
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestRolloutStatus(t *testing.T) {
+	tests := []struct {
+		desc     string
+		statusFn rolloutStatusFunc
+		object   map[string]any
+		wantDone bool
+		wantErr  bool
+	}{
+		{
+			desc:     "Daemonset generation not observed",
+			statusFn: daemonsetRolloutStatus,
+			object:   rolloutObject(2, nil, map[string]any{"observedGeneration": int64(1), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(3)}),
+		},
+		{
+			desc:     "Daemonset pods being updated",
+			statusFn: daemonsetRolloutStatus,
+			object:   rolloutObject(2, nil, map[string]any{"observedGeneration": int64(2), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(1), "numberAvailable": int64(3)}),
+		},
+		{
+			desc:     "Daemonset updated pods not available",
+			statusFn: daemonsetRolloutStatus,
+			object:   rolloutObject(2, nil, map[string]any{"observedGeneration": int64(2), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(2)}),
+		},
+		{
+			desc:     "Daemonset rolled out",
+			statusFn: daemonsetRolloutStatus,
+			object:   rolloutObject(2, nil, map[string]any{"observedGeneration": int64(2), "desiredNumberScheduled": int64(3), "updatedNumberScheduled": int64(3), "numberAvailable": int64(3)}),
+			wantDone: true,
+		},
+		{
+			desc:     "Daemonset with OnDelete strategy",
+			statusFn: daemonsetRolloutStatus,
+			object:   rolloutObject(2, map[string]any{"updateStrategy": map[string]any{"type": "OnDelete"}}, map[string]any{"observedGeneration": int64(2)}),
+			wantErr:  true,
+		},
+		{
+			desc:     "Deployment old replicas pending termination",
+			statusFn: deploymentRolloutStatus,
+			object:   rolloutObject(3, map[string]any{"replicas": int64(2)}, map[string]any{"observedGeneration": int64(3), "replicas": int64(3), "updatedReplicas": int64(2), "availableReplicas": int64(2)}),
+		},
+		{
+			desc:     "Deployment rolled out",
+			statusFn: deploymentRolloutStatus,
+			object:   rolloutObject(3, map[string]any{"replicas": int64(2)}, map[string]any{"observedGeneration": int64(3), "replicas": int64(2), "updatedReplicas": int64(2), "availableReplicas": int64(2)}),
+			wantDone: true,
+		},
+		{
+			desc:     "Deployment exceeded progress deadline",
+			statusFn: deploymentRolloutStatus,
+			object: rolloutObject(3, map[string]any{"replicas": int64(2)}, map[string]any{
+				"observedGeneration": int64(3),
+				"conditions":         []any{map[string]any{"type": "Progressing", "status": "False", "reason": "ProgressDeadlineExceeded"}},
+			}),
+			wantErr: true,
+		},
+		{
+			desc:     "Statefulset revisions differ",
+			statusFn: statefulsetRolloutStatus,
+			object:   rolloutObject(4, map[string]any{"replicas": int64(2)}, map[string]any{"observedGeneration": int64(4), "readyReplicas": int64(2), "updatedReplicas": int64(1), "currentRevision": "rev-1", "updateRevision": "rev-2"}),
+		},
+		{
+			desc:     "Statefulset rolled out",
+			statusFn: statefulsetRolloutStatus,
+			object:   rolloutObject(4, map[string]any{"replicas": int64(2)}, map[string]any{"observedGeneration": int64(4), "readyReplicas": int64(2), "updatedReplicas": int64(2), "currentRevision": "rev-2", "updateRevision": "rev-2"}),
+			wantDone: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			done, status, err := tc.statusFn(&unstructured.Unstructured{Object: tc.object})
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("Wanted an error, got nil instead")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if done != tc.wantDone {
+				t.Fatalf("Wanted 'done' return value as %t, got %t instead (status: %s)", tc.wantDone, done, status)
+			}
+		})
+	}
+}
+
+func rolloutObject(generation int64, spec, status map[string]any) map[string]any {
+	if spec == nil {
+		spec = map[string]any{}
+	}
+	return map[string]any{
+		"metadata": map[string]any{
+			"name":       "my-resource",
+			"generation": generation,
+		},
+		"spec":   spec,
+		"status": status,
+	}
+}
+
+func TestPodFailure(t *testing.T) {
+	tests := []struct {
+		desc    string
+		pod     *corev1.Pod
+		wantErr bool
+	}{
+		{
+			desc:    "New image crash looping",
+			pod:     podWithWaitingReason("gcr.io/my-project/image:v0.1.49", "CrashLoopBackOff"),
+			wantErr: true,
+		},
+		{
+			desc:    "New image pull failing",
+			pod:     podWithWaitingReason("gcr.io/my-project/image:v0.1.49", "ImagePullBackOff"),
+			wantErr: true,
+		},
+		{
+			desc: "New image first pull failed",
+			pod:  podWithWaitingReason("gcr.io/my-project/image:v0.1.49", "ErrImagePull"),
+		},
+		{
+			desc: "New image container creating",
+			pod:  podWithWaitingReason("gcr.io/my-project/image:v0.1.49", "ContainerCreating"),
+		},
+		{
+			desc: "Old image crash looping",
+			pod:  podWithWaitingReason("gcr.io/my-project/image:v0.1.47", "CrashLoopBackOff"),
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := podFailure(tc.pod, "gcr.io/my-project/image:v0.1.49")
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func podWithWaitingReason(image, reason string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-pod"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "my_container", Image: image}},
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name:  "my_container",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}},
+				},
+			},
+		},
+	}
+}
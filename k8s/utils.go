@@ -6,57 +6,363 @@ import (
 	"reflect"
 	"regexp"
 	"strconv"
-	"strings"
 
-	"github.com/fatih/structs"
-)
+	"github.com/edgedelta/updater/core"
 
-var (
-	sliceRe = regexp.MustCompile(`([^\[\]]+)\[(\d+)\]$`)
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// FieldValue is the value of a single field matched by a key path.
+type FieldValue struct {
+	// Path is the resource path of the field, set by the Client
+	Path core.K8sResourcePath
+	// KeyPath is the key path of the field, with the multi-element selectors (e.g. "[*]")
+	// replaced by the indexes of the matched elements
+	KeyPath []string
+	// Value is the unstructured value of the field, nil if the field does not exist
+	Value any
+}
+
+// FieldChange is the outcome of setting a single field matched by a key path.
+type FieldChange struct {
+	// Path is the resource path of the field, set by the Client
+	Path core.K8sResourcePath
+	// KeyPath is the key path of the field, with the multi-element selectors (e.g. "[*]")
+	// replaced by the indexes of the matched elements
+	KeyPath []string
+	// Old is the unstructured value of the field before the change, nil if the field did not exist
+	Old any
+	// Value is the unstructured value the field is set to, set by the Client
+	Value   any
+	Updated bool
+	// Err is the error of updating the field's resource, set by the Client
+	Err error
+}
+
+// field is a field matched by a key path. A field is either a value of an object or an element of
+// a slice, get and set access it in place. pointer is the unescaped JSON pointer (RFC 6901) tokens
+// of the field, which end with "-" for an element appended to a slice. appended is set for an
+// element appended to a slice which does not exist yet, in which case pointer is the slice's.
+type field struct {
+	get      func() any
+	set      func(any)
+	keyPath  []string
+	pointer  []string
+	appended bool
+}
+
+// CompareAndUpdateStructField sets the string field at the given path of o to setValue and returns
+// the old value together with whether an update happened. It fails if the path matches more than
+// one field, see CompareAndUpdateStructFields for paths with multi-element selectors and for
+// values of other types.
 func CompareAndUpdateStructField(o any, path []string, setValue string) (string, bool, error) {
-	if len(path) == 0 {
-		return "", false, errors.New("no path specified")
+	changes, err := CompareAndUpdateStructFields(o, path, setValue)
+	if err != nil {
+		return "", false, err
+	}
+	if len(changes) != 1 {
+		return "", false, fmt.Errorf("path matches %d fields, expected exactly one", len(changes))
+	}
+	return core.FormatValue(changes[0].Old), changes[0].Updated, nil
+}
+
+// CompareAndUpdateStructFields sets each field matched by the given path of o to setValue and
+// returns a change for each of them. o is either the content of an unstructured object
+// (map[string]any) or a pointer to a typed K8s object, which is converted to its unstructured form
+// and back so both share the same path semantics.
+//
+// setValue is an unstructured value, i.e. a string, int64, float64, bool, map[string]any or []any,
+// see core.ParseValue. It must have the same type as the existing values of the fields. Strings
+// pinning the same digest of the same image as setValue are left unchanged. The path can end with
+// a slice element, e.g. "env[name=AGENT_VERSION]", in which case the element is replaced, or
+// appended if no element matches the field value selector.
+func CompareAndUpdateStructFields(o any, path []string, setValue any) ([]FieldChange, error) {
+	if m, ok := o.(map[string]any); ok {
+		changes, _, err := compareAndUpdateUnstructuredFields(m, path, setValue)
+		return changes, err
 	}
-	fields := structs.Fields(o)
-	lookForTag := path[0]
-	wantSlice := false
-	var sliceIndex uint64
-	if match := sliceRe.FindStringSubmatch(lookForTag); match != nil {
+	m, err := runtime.DefaultUnstructuredConverter.ToUnstructured(o)
+	if err != nil {
+		return nil, fmt.Errorf("runtime.UnstructuredConverter.ToUnstructured: %v", err)
+	}
+	changes, _, err := compareAndUpdateUnstructuredFields(m, path, setValue)
+	if err != nil || !anyUpdated(changes) {
+		return changes, err
+	}
+	// Decode into a zero value so that nothing from the previous state survives the round trip
+	v := reflect.New(reflect.TypeOf(o).Elem())
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(m, v.Interface()); err != nil {
+		return nil, fmt.Errorf("runtime.UnstructuredConverter.FromUnstructured: %v", err)
+	}
+	reflect.ValueOf(o).Elem().Set(v.Elem())
+	return changes, nil
+}
+
+// GetStructField returns the value of the string field at the given path of o, which is either the
+// content of an unstructured object or a pointer to a typed K8s object. It fails if the path matches
+// more than one field or the field is not a string.
+func GetStructField(o any, path []string) (string, error) {
+	values, err := GetStructFields(o, path)
+	if err != nil {
+		return "", err
+	}
+	if len(values) != 1 {
+		return "", fmt.Errorf("path matches %d fields, expected exactly one", len(values))
+	}
+	if s, ok := values[0].Value.(string); ok || values[0].Value == nil {
+		return s, nil
+	}
+	return "", fmt.Errorf("expected '%s' to be a string, got %T instead", path[len(path)-1], values[0].Value)
+}
+
+// GetStructFields returns the values of the fields matched by the given path of o.
+func GetStructFields(o any, path []string) ([]FieldValue, error) {
+	m, ok := o.(map[string]any)
+	if !ok {
 		var err error
-		sliceIndex, err = strconv.ParseUint(match[2], 10, 64)
-		if err != nil {
-			return "", false, fmt.Errorf("failed to parse slice index '%s' to uint64, err: %v", match[2], err)
+		if m, err = runtime.DefaultUnstructuredConverter.ToUnstructured(o); err != nil {
+			return nil, fmt.Errorf("runtime.UnstructuredConverter.ToUnstructured: %v", err)
 		}
-		wantSlice = true
-		lookForTag = match[1]
 	}
+	fields, err := lookupUnstructuredFields(m, path, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]FieldValue, 0, len(fields))
 	for _, f := range fields {
-		sp := strings.Split(f.Tag("json"), ",")
-		tag := sp[0]
-		if tag != lookForTag {
+		values = append(values, FieldValue{KeyPath: f.keyPath, Value: f.get()})
+	}
+	return values, nil
+}
+
+// compareAndUpdateUnstructuredFields returns the changed fields along with their changes.
+func compareAndUpdateUnstructuredFields(o map[string]any, path []string, setValue any) ([]FieldChange, []field, error) {
+	fields, err := lookupUnstructuredFields(o, path, nil, nil, setValue)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Check all the old values first so that nothing is modified if one of them has another type
+	changes := make([]FieldChange, 0, len(fields))
+	for _, f := range fields {
+		old := f.get()
+		if err := checkValueType(f.keyPath[len(f.keyPath)-1], old, setValue); err != nil {
+			return nil, nil, err
+		}
+		changes = append(changes, FieldChange{KeyPath: f.keyPath, Old: old})
+	}
+	for i, f := range fields {
+		if equalValues(changes[i].Old, setValue) {
 			continue
 		}
+		f.set(setValue)
+		changes[i].Updated = true
+	}
+	return changes, fields, nil
+}
+
+func anyUpdated(changes []FieldChange) bool {
+	for _, c := range changes {
+		if c.Updated {
+			return true
+		}
+	}
+	return false
+}
+
+// valueKind returns the JSON kind of an unstructured value, all numbers share the same kind.
+func valueKind(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case int64, int32, int, float64, float32:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]any:
+		return "object"
+	case []any:
+		return "list"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// checkValueType returns an error if the new value does not have the same type as the existing one.
+func checkValueType(key string, old, new any) error {
+	if old == nil {
+		return nil
+	}
+	if oldKind, newKind := valueKind(old), valueKind(new); oldKind != newKind {
+		return fmt.Errorf("can not set '%s' of type %s to a value of type %s", key, oldKind, newKind)
+	}
+	return nil
+}
+
+func equalValues(old, new any) bool {
+	if reflect.DeepEqual(old, new) {
+		return true
+	}
+	oldStr, ok := old.(string)
+	if !ok {
+		return false
+	}
+	newStr, ok := new.(string)
+	return ok && core.DigestEquivalent(oldStr, newStr)
+}
+
+// lookupUnstructuredFields walks the given path and returns the fields it matches. The leaf fields
+// themselves do not need to exist. Slice elements are selected by index, e.g. "containers[0]", by
+// the value of one of their fields, e.g. "containers[name=agent]", by a regular expression matching
+// one of their fields, e.g. "containers[image~=edgedelta/agent]", or all of them with
+// "containers[*]". walked is the already walked part of the path and pointer its JSON pointer
+// tokens. setValue is the value to be set, nil for lookups, which is appended to the slice if the
+// path ends with a field value selector which matches no element.
+func lookupUnstructuredFields(o map[string]any, path, walked, pointer []string, setValue any) ([]field, error) {
+	if len(path) == 0 {
+		return nil, errors.New("no path specified")
+	}
+	seg, err := core.ParseKeyPathSegment(path[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 1 && seg.Element == nil {
+		return []field{{
+			get:     func() any { return o[seg.Field] },
+			set:     func(v any) { o[seg.Field] = v },
+			keyPath: append(walked, seg.Field),
+			pointer: extend(pointer, seg.Field),
+		}}, nil
+	}
+	obj, ok := o[seg.Field]
+	if !ok {
+		if len(path) == 1 && setValue != nil && appendable(seg, setValue) {
+			return []field{appendField(o, seg, nil, walked, pointer, path[0])}, nil
+		}
+		return nil, fmt.Errorf("could not find field %s in object", seg.Field)
+	}
+	if seg.Element == nil {
+		next, ok := obj.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected '%s' to be an object, got %T instead", path[0], obj)
+		}
+		return lookupUnstructuredFields(next, path[1:], append(walked, path[0]), extend(pointer, seg.Field), setValue)
+	}
+	sl, ok := obj.([]any)
+	if !ok {
+		return nil, fmt.Errorf("expected '%s' to be a slice, got %T instead", seg.Field, obj)
+	}
+	indexes, err := selectElements(sl, seg)
+	if err != nil {
+		if len(path) == 1 && setValue != nil && appendable(seg, setValue) && len(indexes) == 0 {
+			return []field{appendField(o, seg, sl, walked, pointer, path[0])}, nil
+		}
+		return nil, err
+	}
+	fields := make([]field, 0, len(indexes))
+	for _, i := range indexes {
+		i := i
+		// Multi-element selectors are replaced by the element's index so that the field can be
+		// addressed on its own later on, e.g. for a rollback
+		s := path[0]
+		if seg.Element.Multi() {
+			s = fmt.Sprintf("%s[%d]", seg.Field, i)
+		}
+		// The elements' paths diverge from here on, so each gets its own copy
+		elemWalked := extend(walked, s)
+		elemPointer := extend(pointer, seg.Field, strconv.Itoa(i))
 		if len(path) == 1 {
-			if wantSlice {
-				return "", false, errors.New("directly setting a slice element is not supported")
-			}
-			oldVal := f.Value().(string)
-			if setValue == oldVal {
-				return oldVal, false, nil
-			}
-			return oldVal, true, f.Set(setValue)
-		}
-		var obj any = f.Value()
-		if wantSlice {
-			if f.Kind() != reflect.Slice {
-				return "", false, fmt.Errorf("expected '%s' to be a slice, got %s instead", lookForTag, f.Kind().String())
-			}
-			obj = reflect.ValueOf(f.Value()).Index(int(sliceIndex)).Addr().Interface()
-		}
-		newPath := path[1:]
-		return CompareAndUpdateStructField(obj, newPath, setValue)
-	}
-	return "", false, fmt.Errorf("could not find field with JSON tag %s in object %+v", lookForTag, o)
+			fields = append(fields, field{
+				get:     func() any { return sl[i] },
+				set:     func(v any) { sl[i] = v },
+				keyPath: elemWalked,
+				pointer: elemPointer,
+			})
+			continue
+		}
+		next, ok := sl[i].(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("expected '%s' to be an object, got %T instead", path[0], sl[i])
+		}
+		f, err := lookupUnstructuredFields(next, path[1:], elemWalked, elemPointer, setValue)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", s, err)
+		}
+		fields = append(fields, f...)
+	}
+	return fields, nil
+}
+
+// appendable reports whether the value can be appended to the slice of a path ending with the given
+// segment when no element matches, i.e. the segment selects by field value and the value is an
+// object with that field value, so that it is matched the next time.
+func appendable(seg core.KeyPathSegment, setValue any) bool {
+	if seg.Element == nil || seg.Element.Index >= 0 || seg.Element.Multi() {
+		return false
+	}
+	m, ok := setValue.(map[string]any)
+	return ok && m[seg.Element.Key] == seg.Element.Value
+}
+
+// appendField returns a field which does not exist yet and appends its value to the slice, which
+// is created if sl is nil.
+func appendField(o map[string]any, seg core.KeyPathSegment, sl []any, walked, pointer []string, segment string) field {
+	f := field{
+		get:     func() any { return nil },
+		set:     func(v any) { o[seg.Field] = append(sl, v) },
+		keyPath: append(walked, segment),
+		pointer: extend(pointer, seg.Field, "-"),
+	}
+	if sl == nil {
+		f.pointer, f.appended = extend(pointer, seg.Field), true
+	}
+	return f
+}
+
+// extend returns a copy of s with the given elements appended, so that the diverging paths of
+// several fields do not share their backing arrays.
+func extend(s []string, elems ...string) []string {
+	return append(append(make([]string, 0, len(s)+len(elems)), s...), elems...)
+}
+
+// selectElements returns the indexes of the slice elements selected by the segment's selector.
+// Index and exact value selectors must select exactly one element, the others at least one.
+func selectElements(sl []any, seg core.KeyPathSegment) ([]int, error) {
+	sel := seg.Element
+	if sel.Index >= 0 {
+		if sel.Index >= len(sl) {
+			return nil, fmt.Errorf("index %d is out of range for '%s' with length %d", sel.Index, seg.Field, len(sl))
+		}
+		return []int{sel.Index}, nil
+	}
+	var re *regexp.Regexp
+	if sel.Regexp {
+		var err error
+		if re, err = regexp.Compile(sel.Value); err != nil {
+			return nil, fmt.Errorf("regexp.Compile: %v", err)
+		}
+	}
+	indexes := make([]int, 0)
+	for i, e := range sl {
+		if sel.All {
+			indexes = append(indexes, i)
+			continue
+		}
+		m, ok := e.(map[string]any)
+		if !ok {
+			continue
+		}
+		v, ok := m[sel.Key].(string)
+		if !ok {
+			continue
+		}
+		if (re != nil && re.MatchString(v)) || (re == nil && v == sel.Value) {
+			indexes = append(indexes, i)
+		}
+	}
+	if len(indexes) == 0 {
+		return nil, fmt.Errorf("no element of '%s' matches selector %s", seg.Field, sel)
+	}
+	if !sel.Multi() && len(indexes) > 1 {
+		return indexes, fmt.Errorf("%d elements of '%s' match selector %s, expected exactly one", len(indexes), seg.Field, sel)
+	}
+	return indexes, nil
 }
@@ -37,6 +37,60 @@ func TestCompareAndUpdateStructField(t *testing.T) {
 			wantObject:  daemonsetWithImage("gcr.io/my-project/image:v0.1.47"),
 			wantOld:     "gcr.io/my-project/image:v0.1.47",
 		},
+		{
+			desc:        "Update unstructured custom resource image",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "template", "spec", "containers[0]", "image"},
+			updateValue: "gcr.io/my-project/image:v0.1.49",
+			wantUpdated: true,
+			wantObject:  rolloutWithImage("gcr.io/my-project/image:v0.1.49"),
+			wantOld:     "gcr.io/my-project/image:v0.1.47",
+		},
+		{
+			desc:        "No update unstructured custom resource image",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "template", "spec", "containers[0]", "image"},
+			updateValue: "gcr.io/my-project/image:v0.1.47",
+			wantUpdated: false,
+			wantObject:  rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			wantOld:     "gcr.io/my-project/image:v0.1.47",
+		},
+		{
+			desc:        "digest equivalent value is unchanged",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47@sha256:0123456789abcdef"),
+			path:        []string{"spec", "template", "spec", "containers[0]", "image"},
+			updateValue: "gcr.io/my-project/image@sha256:0123456789abcdef",
+			wantUpdated: false,
+			wantObject:  rolloutWithImage("gcr.io/my-project/image:v0.1.47@sha256:0123456789abcdef"),
+			wantOld:     "gcr.io/my-project/image:v0.1.47@sha256:0123456789abcdef",
+		},
+		{
+			desc:        "tag is pinned to digest",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "template", "spec", "containers[0]", "image"},
+			updateValue: "gcr.io/my-project/image:v0.1.47@sha256:0123456789abcdef",
+			wantUpdated: true,
+			wantObject:  rolloutWithImage("gcr.io/my-project/image:v0.1.47@sha256:0123456789abcdef"),
+			wantOld:     "gcr.io/my-project/image:v0.1.47",
+		},
+		{
+			desc:        "Container selected by name",
+			object:      rolloutWithContainers(container("istio-proxy", "istio/proxyv2:1.18.0"), container("agent", "gcr.io/my-project/image:v0.1.47")),
+			path:        []string{"spec", "template", "spec", "containers[name=agent]", "image"},
+			updateValue: "gcr.io/my-project/image:v0.1.49",
+			wantUpdated: true,
+			wantObject:  rolloutWithContainers(container("istio-proxy", "istio/proxyv2:1.18.0"), container("agent", "gcr.io/my-project/image:v0.1.49")),
+			wantOld:     "gcr.io/my-project/image:v0.1.47",
+		},
+		{
+			desc:        "Typed object container selected by name",
+			object:      daemonsetWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "template", "spec", "containers[name=my_container]", "image"},
+			updateValue: "gcr.io/my-project/image:v0.1.49",
+			wantUpdated: true,
+			wantObject:  daemonsetWithImage("gcr.io/my-project/image:v0.1.49"),
+			wantOld:     "gcr.io/my-project/image:v0.1.47",
+		},
 	}
 	for _, tc := range tests {
 		t.Run(tc.desc, func(t *testing.T) {
@@ -57,6 +111,303 @@ func TestCompareAndUpdateStructField(t *testing.T) {
 	}
 }
 
+func TestCompareAndUpdateStructFields(t *testing.T) {
+	tests := []struct {
+		desc        string
+		object      map[string]any
+		path        []string
+		updateValue string
+		wantObject  map[string]any
+		wantChanges []FieldChange
+	}{
+		{
+			desc: "Every container",
+			object: rolloutWithContainers(
+				container("agent", "gcr.io/edgedelta/agent:v0.1.47"),
+				container("debug", "gcr.io/edgedelta/agent:v0.1.49"),
+			),
+			path:        []string{"spec", "template", "spec", "containers[*]", "image"},
+			updateValue: "gcr.io/edgedelta/agent:v0.1.49",
+			wantObject: rolloutWithContainers(
+				container("agent", "gcr.io/edgedelta/agent:v0.1.49"),
+				container("debug", "gcr.io/edgedelta/agent:v0.1.49"),
+			),
+			wantChanges: []FieldChange{
+				{KeyPath: []string{"spec", "template", "spec", "containers[0]", "image"}, Old: "gcr.io/edgedelta/agent:v0.1.47", Updated: true},
+				{KeyPath: []string{"spec", "template", "spec", "containers[1]", "image"}, Old: "gcr.io/edgedelta/agent:v0.1.49"},
+			},
+		},
+		{
+			desc: "Containers matching image",
+			object: rolloutWithContainers(
+				container("istio-proxy", "istio/proxyv2:1.18.0"),
+				container("agent", "gcr.io/edgedelta/agent:v0.1.47"),
+				container("agent-debug", "gcr.io/edgedelta/agent:v0.1.48"),
+			),
+			path:        []string{"spec", "template", "spec", "containers[image~=edgedelta/agent:]", "image"},
+			updateValue: "gcr.io/edgedelta/agent:v0.1.49",
+			wantObject: rolloutWithContainers(
+				container("istio-proxy", "istio/proxyv2:1.18.0"),
+				container("agent", "gcr.io/edgedelta/agent:v0.1.49"),
+				container("agent-debug", "gcr.io/edgedelta/agent:v0.1.49"),
+			),
+			wantChanges: []FieldChange{
+				{KeyPath: []string{"spec", "template", "spec", "containers[1]", "image"}, Old: "gcr.io/edgedelta/agent:v0.1.47", Updated: true},
+				{KeyPath: []string{"spec", "template", "spec", "containers[2]", "image"}, Old: "gcr.io/edgedelta/agent:v0.1.48", Updated: true},
+			},
+		},
+		{
+			desc:        "Single element selector keeps its form",
+			object:      rolloutWithContainers(container("agent", "gcr.io/edgedelta/agent:v0.1.47")),
+			path:        []string{"spec", "template", "spec", "containers[name=agent]", "image"},
+			updateValue: "gcr.io/edgedelta/agent:v0.1.49",
+			wantObject:  rolloutWithContainers(container("agent", "gcr.io/edgedelta/agent:v0.1.49")),
+			wantChanges: []FieldChange{
+				{KeyPath: []string{"spec", "template", "spec", "containers[name=agent]", "image"}, Old: "gcr.io/edgedelta/agent:v0.1.47", Updated: true},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := CompareAndUpdateStructFields(tc.object, tc.path, tc.updateValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.wantChanges, got); diff != "" {
+				t.Errorf("Changes mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantObject, tc.object); diff != "" {
+				t.Errorf("Objects mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompareAndUpdateStructFieldsTypedValues(t *testing.T) {
+	agentWithEnv := func(env ...any) map[string]any {
+		c := container("agent", "gcr.io/edgedelta/agent:v0.1.47")
+		if env != nil {
+			c["env"] = env
+		}
+		return rolloutWithContainers(c)
+	}
+	envVar := func(name, value string) map[string]any {
+		return map[string]any{"name": name, "value": value}
+	}
+	tests := []struct {
+		desc        string
+		object      map[string]any
+		path        []string
+		updateValue any
+		wantObject  map[string]any
+		wantChanges []FieldChange
+	}{
+		{
+			desc:        "Integer",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "replicas"},
+			updateValue: int64(5),
+			wantObject: func() map[string]any {
+				o := rolloutWithImage("gcr.io/my-project/image:v0.1.47")
+				o["spec"].(map[string]any)["replicas"] = int64(5)
+				return o
+			}(),
+			wantChanges: []FieldChange{{KeyPath: []string{"spec", "replicas"}, Old: int64(3), Updated: true}},
+		},
+		{
+			desc:        "Unchanged integer",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "replicas"},
+			updateValue: int64(3),
+			wantObject:  rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			wantChanges: []FieldChange{{KeyPath: []string{"spec", "replicas"}, Old: int64(3)}},
+		},
+		{
+			desc:        "New boolean",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "paused"},
+			updateValue: true,
+			wantObject: func() map[string]any {
+				o := rolloutWithImage("gcr.io/my-project/image:v0.1.47")
+				o["spec"].(map[string]any)["paused"] = true
+				return o
+			}(),
+			wantChanges: []FieldChange{{KeyPath: []string{"spec", "paused"}, Updated: true}},
+		},
+		{
+			desc:        "Replaced env var entry",
+			object:      agentWithEnv(envVar("LOG_LEVEL", "info"), envVar("AGENT_VERSION", "v0.1.47")),
+			path:        []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+			updateValue: envVar("AGENT_VERSION", "v0.1.49"),
+			wantObject:  agentWithEnv(envVar("LOG_LEVEL", "info"), envVar("AGENT_VERSION", "v0.1.49")),
+			wantChanges: []FieldChange{{
+				KeyPath: []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+				Old:     envVar("AGENT_VERSION", "v0.1.47"),
+				Updated: true,
+			}},
+		},
+		{
+			desc:        "Appended env var entry",
+			object:      agentWithEnv(envVar("LOG_LEVEL", "info")),
+			path:        []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+			updateValue: envVar("AGENT_VERSION", "v0.1.49"),
+			wantObject:  agentWithEnv(envVar("LOG_LEVEL", "info"), envVar("AGENT_VERSION", "v0.1.49")),
+			wantChanges: []FieldChange{{
+				KeyPath: []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+				Updated: true,
+			}},
+		},
+		{
+			desc:        "First env var entry",
+			object:      agentWithEnv(),
+			path:        []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+			updateValue: envVar("AGENT_VERSION", "v0.1.49"),
+			wantObject:  agentWithEnv(envVar("AGENT_VERSION", "v0.1.49")),
+			wantChanges: []FieldChange{{
+				KeyPath: []string{"spec", "template", "spec", "containers[name=agent]", "env[name=AGENT_VERSION]"},
+				Updated: true,
+			}},
+		},
+		{
+			desc:        "Sub-object",
+			object:      rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:        []string{"spec", "template", "spec", "containers[0]", "resources"},
+			updateValue: map[string]any{"limits": map[string]any{"memory": "512Mi"}},
+			wantObject: func() map[string]any {
+				o := rolloutWithImage("gcr.io/my-project/image:v0.1.47")
+				c := o["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)["containers"].([]any)[0].(map[string]any)
+				c["resources"] = map[string]any{"limits": map[string]any{"memory": "512Mi"}}
+				return o
+			}(),
+			wantChanges: []FieldChange{{KeyPath: []string{"spec", "template", "spec", "containers[0]", "resources"}, Updated: true}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			got, err := CompareAndUpdateStructFields(tc.object, tc.path, tc.updateValue)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.wantChanges, got); diff != "" {
+				t.Errorf("Changes mismatch (-want +got):\n%s", diff)
+			}
+			if diff := cmp.Diff(tc.wantObject, tc.object); diff != "" {
+				t.Errorf("Objects mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompareAndUpdateStructFieldsTypeMismatch(t *testing.T) {
+	tests := []struct {
+		desc        string
+		path        []string
+		updateValue any
+	}{
+		{desc: "String to integer field", path: []string{"spec", "replicas"}, updateValue: "5"},
+		{desc: "Integer to string field", path: []string{"spec", "template", "spec", "containers[0]", "image"}, updateValue: int64(5)},
+		{desc: "Object to string field", path: []string{"spec", "template", "spec", "containers[0]", "name"}, updateValue: map[string]any{"a": "b"}},
+		{desc: "Env var entry without selected name", path: []string{"spec", "template", "spec", "containers[0]", "env[name=AGENT_VERSION]"}, updateValue: map[string]any{"name": "OTHER"}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			o := rolloutWithImage("gcr.io/my-project/image:v0.1.47")
+			if _, err := CompareAndUpdateStructFields(o, tc.path, tc.updateValue); err == nil {
+				t.Fatal("Wanted an error, got nil instead")
+			}
+			if diff := cmp.Diff(rolloutWithImage("gcr.io/my-project/image:v0.1.47"), o); diff != "" {
+				t.Errorf("Object is modified (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompareAndUpdateStructFieldsTypedObject(t *testing.T) {
+	ds := daemonsetWithImage("gcr.io/my-project/image:v0.1.47")
+	path := []string{"spec", "template", "spec", "containers[name=my_container]", "env[name=AGENT_VERSION]"}
+	if _, err := CompareAndUpdateStructFields(ds, path, map[string]any{"name": "AGENT_VERSION", "value": "v0.1.49"}); err != nil {
+		t.Fatal(err)
+	}
+	env := ds.Spec.Template.Spec.Containers[0].Env
+	if len(env) != 2 || env[1].Name != "AGENT_VERSION" || env[1].Value != "v0.1.49" {
+		t.Errorf("Wanted AGENT_VERSION env var to be appended, got %+v instead", env)
+	}
+}
+
+func TestGetStructField(t *testing.T) {
+	path := []string{"spec", "template", "spec", "containers[0]", "image"}
+	for _, o := range []any{daemonsetWithImage("gcr.io/my-project/image:v0.1.47"), rolloutWithImage("gcr.io/my-project/image:v0.1.47")} {
+		got, err := GetStructField(o, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != "gcr.io/my-project/image:v0.1.47" {
+			t.Fatalf("Wanted value gcr.io/my-project/image:v0.1.47, got %s instead", got)
+		}
+	}
+}
+
+func TestCompareAndUpdateStructFieldErrors(t *testing.T) {
+	tests := []struct {
+		desc   string
+		object any
+		path   []string
+	}{
+		{
+			desc:   "Missing intermediate field",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "strategy", "image"},
+		},
+		{
+			desc:   "Slice index out of range",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "template", "spec", "containers[1]", "image"},
+		},
+		{
+			desc:   "Non-string leaf",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "replicas"},
+		},
+		{
+			desc:   "No element matches selector",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "template", "spec", "containers[name=agent]", "image"},
+		},
+		{
+			desc:   "Several elements match selector",
+			object: rolloutWithContainers(container("agent", "gcr.io/my-project/image:v0.1.47"), container("agent", "gcr.io/my-project/image:v0.1.47")),
+			path:   []string{"spec", "template", "spec", "containers[name=agent]", "image"},
+		},
+		{
+			desc:   "Invalid selector",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "template", "spec", "containers[agent]", "image"},
+		},
+		{
+			desc:   "Several fields match single field update",
+			object: rolloutWithContainers(container("agent", "gcr.io/my-project/image:v0.1.47"), container("debug", "gcr.io/my-project/image:v0.1.47")),
+			path:   []string{"spec", "template", "spec", "containers[*]", "image"},
+		},
+		{
+			desc:   "No element matches regular expression",
+			object: rolloutWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "template", "spec", "containers[image~=edgedelta]", "image"},
+		},
+		{
+			desc:   "Slice element as leaf",
+			object: daemonsetWithImage("gcr.io/my-project/image:v0.1.47"),
+			path:   []string{"spec", "template", "spec", "containers[0]"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			if _, _, err := CompareAndUpdateStructField(tc.object, tc.path, "gcr.io/my-project/image:v0.1.49"); err == nil {
+				t.Fatal("Wanted an error, got nil instead")
+			}
+		})
+	}
+}
+
 func daemonsetWithImage(image string) *appsv1.DaemonSet {
 	return &appsv1.DaemonSet{
 		ObjectMeta: metav1.ObjectMeta{
@@ -93,3 +444,37 @@ func daemonsetWithImage(image string) *appsv1.DaemonSet {
 		},
 	}
 }
+
+func rolloutWithImage(image string) map[string]any {
+	return map[string]any{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata": map[string]any{
+			"name":      "my-rollout",
+			"namespace": "my-namespace",
+		},
+		"spec": map[string]any{
+			"replicas": int64(3),
+			"template": map[string]any{
+				"spec": map[string]any{
+					"containers": []any{
+						map[string]any{
+							"name":  "my_container",
+							"image": image,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func rolloutWithContainers(containers ...any) map[string]any {
+	o := rolloutWithImage("")
+	o["spec"].(map[string]any)["template"].(map[string]any)["spec"].(map[string]any)["containers"] = containers
+	return o
+}
+
+func container(name, image string) map[string]any {
+	return map[string]any{"name": name, "image": image}
+}
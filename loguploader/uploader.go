@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/edgedelta/updater/api"
+	"github.com/edgedelta/updater/metrics"
 
 	zerolog "github.com/rs/zerolog/log"
 )
@@ -14,9 +15,14 @@ import (
 const (
 	uploaderChanBufferSize = 100
 	uploaderFlushInterval  = time.Minute
+	// uploaderMaxBufferSize bounds the lines kept while the uploads fail, the oldest lines are
+	// dropped beyond it
+	uploaderMaxBufferSize = 10000
 )
 
 type Uploader struct {
+	ctx        context.Context
+	stopCtx    context.Context
 	name       string
 	incoming   chan string
 	buffer     []string
@@ -37,6 +43,7 @@ func (w *incomingLogWriter) Write(b []byte) (int, error) {
 
 func New(ctx context.Context, name string, cl *api.Client) *Uploader {
 	return &Uploader{
+		ctx:       ctx,
 		name:      name,
 		incoming:  make(chan string, uploaderChanBufferSize),
 		buffer:    make([]string, 0),
@@ -62,8 +69,11 @@ func (u *Uploader) Run() {
 	}
 }
 
-func (u *Uploader) StopBlocking() <-chan struct{} {
+// StopBlocking stops the uploader and returns a channel which is closed once the remaining logs are
+// uploaded. ctx bounds the upload of the remaining logs.
+func (u *Uploader) StopBlocking(ctx context.Context) <-chan struct{} {
 	if atomic.CompareAndSwapInt32(&u.isRunning, 1, 0) {
+		u.stopCtx = ctx
 		u.stop()
 		return u.stopDoneCh
 	}
@@ -84,37 +94,51 @@ func (u *Uploader) run() {
 		select {
 		case <-u.stopCh:
 			zerolog.Debug().Msgf("log.Uploader %s got stop signal, will drain remaining logs", u.name)
-			u.drain()
+			u.drain(u.stopCtx)
 			close(u.stopDoneCh)
 			return
 		case l := <-u.incoming:
 			u.process(l)
 		case <-ticker.C:
-			u.flush()
+			u.flush(u.ctx)
 		}
 	}
 }
 
-func (u *Uploader) drain() {
+func (u *Uploader) drain(ctx context.Context) {
 	for l := range u.incoming {
 		u.process(l)
 	}
-	u.flush()
+	u.flush(ctx)
 }
 
 func (u *Uploader) process(l string) {
 	u.buffer = append(u.buffer, l)
+	if dropped := len(u.buffer) - uploaderMaxBufferSize; dropped > 0 {
+		u.buffer = u.buffer[dropped:]
+		metrics.LogUploaderDroppedLines(dropped)
+	}
+	metrics.SetLogUploaderBufferedLines(len(u.buffer))
 }
 
-func (u *Uploader) flush() {
+func (u *Uploader) flush(ctx context.Context) {
 	size := len(u.buffer)
 	b := make([]interface{}, 0, len(u.buffer))
 	for _, it := range u.buffer {
 		b = append(b, it)
 	}
-	if err := u.cl.UploadLogs(b); err != nil {
-		zerolog.Fatal().Msgf("api.Client.UploadLogs: %v", err)
+	if err := u.cl.UploadLogs(ctx, b); err != nil {
+		if ctx.Err() != nil {
+			// Keep the buffered logs, they are uploaded while stopping the uploader
+			zerolog.Debug().Msgf("log.Uploader %s could not flush logs since its context is done, err: %v", u.name, err)
+			return
+		}
+		// Keep the buffered logs for the next flush
+		metrics.LogUploaderUploadFailed()
+		zerolog.Error().Msgf("log.Uploader %s could not flush %d lines of logs, err: %v", u.name, size, err)
+		return
 	}
 	u.buffer = make([]string, 0)
+	metrics.SetLogUploaderBufferedLines(0)
 	zerolog.Debug().Msgf("log.Uploader %s flushed %d lines of logs", u.name, size)
 }
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/push"
+)
+
+const (
+	namespace = "edgedelta_updater"
+
+	// DefaultPushJob is the job label of the metrics pushed at the end of a one-shot run
+	DefaultPushJob = "edgedelta_updater"
+)
+
+// Reasons of failed entity updates
+const (
+	ReasonLatestTag     = "latest_tag"
+	ReasonVersionPolicy = "version_policy"
+	ReasonImagePolicy   = "image_policy"
+	ReasonSignature     = "signature"
+	ReasonDigest        = "digest"
+	ReasonValues        = "values"
+	ReasonK8s           = "k8s"
+	ReasonRollout       = "rollout"
+	ReasonRollback      = "rollback"
+)
+
+var (
+	registry = prometheus.NewRegistry()
+
+	runs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "runs_total",
+		Help:      "Number of updater runs by result, either success or failure.",
+	}, []string{"result"})
+	runDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "run_duration_seconds",
+		Help:      "Duration of the updater runs, including the rollouts they wait for.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 12),
+	})
+	entityLastSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "entity_last_success_timestamp_seconds",
+		Help:      "Unix time of the last run of an entity without failed or rejected changes.",
+	}, []string{"entity_id"})
+	updateAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_attempts_total",
+		Help:      "Number of times an entity has been processed.",
+	}, []string{"entity_id"})
+	updateFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "update_failures_total",
+		Help:      "Number of failed or rejected entity updates by reason.",
+	}, []string{"entity_id", "reason"})
+	apiRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "api_request_duration_seconds",
+		Help:      "Duration of the requests to the versioning API by operation and status code, \"error\" if no response is received. Each retry is a request of its own.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation", "code"})
+	logUploaderBufferedLines = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "log_uploader_buffered_lines",
+		Help:      "Number of log lines waiting to be uploaded.",
+	})
+	logUploaderDroppedLines = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_uploader_dropped_lines_total",
+		Help:      "Number of the oldest log lines dropped by the log uploader since its buffer is full.",
+	})
+	logUploaderUploadFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "log_uploader_upload_failures_total",
+		Help:      "Number of failed log uploads.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		runs,
+		runDuration,
+		entityLastSuccess,
+		updateAttempts,
+		updateFailures,
+		apiRequestDuration,
+		logUploaderBufferedLines,
+		logUploaderDroppedLines,
+		logUploaderUploadFailures,
+	)
+}
+
+// Handler returns the HTTP handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// Push replaces the metrics of the given job on the Prometheus Pushgateway at url with the current
+// metrics, which is how the metrics of a one-shot run outlive the process.
+func Push(ctx context.Context, url, job string) error {
+	if err := push.New(url, job).Gatherer(registry).PushContext(ctx); err != nil {
+		return fmt.Errorf("push.Pusher.PushContext: %v", err)
+	}
+	return nil
+}
+
+// ObserveRun records a run that took the given duration and failed if err is not nil.
+func ObserveRun(duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	runs.WithLabelValues(result).Inc()
+	runDuration.Observe(duration.Seconds())
+}
+
+// EntityAttempted records that the entity with the given ID is processed by a run.
+func EntityAttempted(entityID string) {
+	updateAttempts.WithLabelValues(entityID).Inc()
+}
+
+// EntityFailed records a failed or rejected update of the entity with the given ID.
+func EntityFailed(entityID, reason string) {
+	updateFailures.WithLabelValues(entityID, reason).Inc()
+}
+
+// EntitySucceeded records the time of a successful run of the entity with the given ID.
+func EntitySucceeded(entityID string, t time.Time) {
+	entityLastSuccess.WithLabelValues(entityID).Set(float64(t.Unix()))
+}
+
+// ObserveAPIRequest records a request to the versioning API. code is the status code of the
+// response, 0 if none is received.
+func ObserveAPIRequest(operation string, code int, duration time.Duration) {
+	c := "error"
+	if code > 0 {
+		c = strconv.Itoa(code)
+	}
+	apiRequestDuration.WithLabelValues(operation, c).Observe(duration.Seconds())
+}
+
+// SetLogUploaderBufferedLines records the number of log lines waiting to be uploaded.
+func SetLogUploaderBufferedLines(n int) {
+	logUploaderBufferedLines.Set(float64(n))
+}
+
+// LogUploaderDroppedLines records n log lines dropped by the log uploader.
+func LogUploaderDroppedLines(n int) {
+	logUploaderDroppedLines.Add(float64(n))
+}
+
+// LogUploaderUploadFailed records a failed log upload.
+func LogUploaderUploadFailed() {
+	logUploaderUploadFailures.Inc()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandler(t *testing.T) {
+	ObserveRun(time.Second, nil)
+	ObserveRun(time.Second, errors.New("failed"))
+	EntityFailed("agent", ReasonRollout)
+	ObserveAPIRequest("latest_tag", 200, time.Millisecond)
+	ObserveAPIRequest("latest_tag", 0, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := io.ReadAll(rec.Body)
+	if err != nil {
+		t.Fatalf("Wanted no error, got %v instead", err)
+	}
+	tests := []string{
+		`edgedelta_updater_runs_total{result="success"} 1`,
+		`edgedelta_updater_runs_total{result="failure"} 1`,
+		`edgedelta_updater_run_duration_seconds_count 2`,
+		`edgedelta_updater_update_failures_total{entity_id="agent",reason="rollout"} 1`,
+		`edgedelta_updater_api_request_duration_seconds_count{code="200",operation="latest_tag"} 1`,
+		`edgedelta_updater_api_request_duration_seconds_count{code="error",operation="latest_tag"} 1`,
+	}
+	for _, want := range tests {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Wanted metrics to contain %s, got %s instead", want, b)
+		}
+	}
+}
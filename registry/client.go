@@ -0,0 +1,348 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/log"
+)
+
+const (
+	defaultTimeout = time.Minute
+	tagsPageSize   = 1000
+)
+
+// manifestMediaTypes are the accepted manifest media types when resolving a digest. Indexes come
+// first so that the digest of a multi-platform image is the one of its index.
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+var (
+	errNotSupported = errors.New("not supported by the registry version source")
+	linkNextRe      = regexp.MustCompile(`<([^>]+)>;\s*rel="?next"?`)
+	challengeRe     = regexp.MustCompile(`(\w+)="([^"]*)"`)
+)
+
+// Client is a core.VersioningServiceClient which reads the tags of the entity images directly from
+// an OCI distribution (Docker) registry and picks the highest semantic version among them. The
+// entity's image name is used as the repository name.
+type Client struct {
+	cl      *http.Client
+	conf    *core.RegistryConfig
+	baseURL *url.URL
+	pattern *regexp.Regexp
+	policy  *core.VersionPolicy
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewClient(conf *core.RegistryConfig) (*Client, error) {
+	if conf == nil {
+		return nil, nil
+	}
+	baseURL, err := url.Parse(strings.TrimSuffix(conf.URL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("url.Parse: %v", err)
+	}
+	if baseURL.Scheme == "" || baseURL.Host == "" {
+		return nil, fmt.Errorf("registry URL %q must have a scheme and a host", conf.URL)
+	}
+	c := &Client{
+		cl:      &http.Client{Timeout: conf.Timeout},
+		conf:    conf,
+		baseURL: baseURL,
+		policy:  &core.VersionPolicy{Constraint: conf.Constraint, AllowPrerelease: conf.AllowPrerelease},
+		tokens:  make(map[string]string),
+	}
+	if c.cl.Timeout <= 0 {
+		c.cl.Timeout = defaultTimeout
+	}
+	if conf.TagPattern != "" {
+		// Anchored like the tag pattern of the image policy, the whole tag must match
+		if c.pattern, err = regexp.Compile(`^(?:` + conf.TagPattern + `)$`); err != nil {
+			return nil, fmt.Errorf("failed to compile tag pattern, err: %v", err)
+		}
+	}
+	if err := c.policy.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetLatestApplicableTag lists the tags of the repository with the given name and returns the
+// highest semantic version which matches the tag pattern and the constraint. It returns a response
+// with an empty tag if no tag is applicable.
+func (c *Client) GetLatestApplicableTag(ctx context.Context, entityID, entityName string) (*core.LatestTagResponse, error) {
+	tags, err := c.listTags(ctx, entityName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags of repository %s, err: %v", entityName, err)
+	}
+	tag := c.latestTag(tags)
+	if tag == "" {
+		log.Debug("registry.Client: None of the %d tags of repository %s is applicable for entity with ID %s", len(tags), entityName, entityID)
+		return &core.LatestTagResponse{Image: entityName}, nil
+	}
+	return &core.LatestTagResponse{
+		Tag:   tag,
+		Image: entityName,
+		URL:   fmt.Sprintf("%s/%s:%s", c.imagePrefix(), entityName, tag),
+	}, nil
+}
+
+// GetDigest returns the digest of the manifest the given tag of the repository points to, as
+// reported by the Docker-Content-Digest header of a manifest HEAD request.
+func (c *Client) GetDigest(ctx context.Context, repository, tag string) (string, error) {
+	u := fmt.Sprintf("%s/v2/%s/manifests/%s", c.baseURL, repository, tag)
+	res, _, err := c.request(ctx, http.MethodHead, u, repository, strings.Join(manifestMediaTypes, ", "))
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest of %s:%s, err: %v", repository, tag, err)
+	}
+	digest := res.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for %s:%s", repository, tag)
+	}
+	return digest, nil
+}
+
+// Repository returns the repository name of the given image reference if the image is served
+// by this registry, i.e. the image name starts with the image prefix.
+func (c *Client) Repository(image string) (string, bool) {
+	name, prefix := core.ParseImageReference(image).Name(), c.imagePrefix()+"/"
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+// Ping checks that the registry is reachable through the version check endpoint of the distribution
+// spec. A registry requiring authentication answers it with 401, which counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	res, _, err := c.do(ctx, http.MethodGet, c.baseURL.String()+"/v2/", "", "")
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status code %d", res.StatusCode)
+	}
+	return nil
+}
+
+func (c *Client) GetPresignedLogUploadURL(ctx context.Context, logSize int) (string, error) {
+	return "", errNotSupported
+}
+
+func (c *Client) UploadLogs(ctx context.Context, lines []any) error {
+	return errNotSupported
+}
+
+func (c *Client) GetMetadata(ctx context.Context) (map[string]string, error) {
+	return nil, errNotSupported
+}
+
+func (c *Client) latestTag(tags []string) string {
+	versions := make([]*semver.Version, 0, len(tags))
+	originals := make(map[*semver.Version]string)
+	for _, t := range tags {
+		if c.pattern != nil && !c.pattern.MatchString(t) {
+			continue
+		}
+		if err := c.policy.CheckTag(t); err != nil {
+			continue
+		}
+		v, err := semver.NewVersion(t)
+		if err != nil {
+			continue
+		}
+		versions = append(versions, v)
+		originals[v] = t
+	}
+	if len(versions) == 0 {
+		return ""
+	}
+	sort.Sort(semver.Collection(versions))
+	return originals[versions[len(versions)-1]]
+}
+
+func (c *Client) imagePrefix() string {
+	if c.conf.ImagePrefix != "" {
+		return strings.TrimSuffix(c.conf.ImagePrefix, "/")
+	}
+	return c.baseURL.Host
+}
+
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
+
+// listTags lists all tags of the repository, following the pagination links.
+func (c *Client) listTags(ctx context.Context, repository string) ([]string, error) {
+	next := fmt.Sprintf("%s/v2/%s/tags/list?n=%d", c.baseURL, repository, tagsPageSize)
+	tags := make([]string, 0)
+	for next != "" {
+		res, data, err := c.request(ctx, http.MethodGet, next, repository, "application/json")
+		if err != nil {
+			return nil, err
+		}
+		var tl tagList
+		if err := json.Unmarshal(data, &tl); err != nil {
+			return nil, fmt.Errorf("json.Unmarshal: %v", err)
+		}
+		tags = append(tags, tl.Tags...)
+		next = ""
+		if m := linkNextRe.FindStringSubmatch(res.Header.Get("Link")); m != nil {
+			u, err := c.baseURL.Parse(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse next page link %q, err: %v", m[1], err)
+			}
+			next = u.String()
+		}
+	}
+	return tags, nil
+}
+
+// request sends a request to the registry, authenticating through the token flow of the
+// distribution spec if the registry challenges it.
+func (c *Client) request(ctx context.Context, method, u, repository, accept string) (*http.Response, []byte, error) {
+	res, data, err := c.do(ctx, method, u, accept, c.authHeader(repository))
+	if err != nil {
+		return nil, nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		auth, err := c.authenticate(ctx, res.Header.Get("WWW-Authenticate"), repository)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to authenticate, err: %v", err)
+		}
+		if res, data, err = c.do(ctx, method, u, accept, auth); err != nil {
+			return nil, nil, err
+		}
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+	}
+	return res, data, nil
+}
+
+func (c *Client) do(ctx context.Context, method, u, accept, authHeader string) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create HTTP request, err: %v", err)
+	}
+	req.Header.Set("Accept", accept)
+	if authHeader != "" {
+		req.Header.Set("Authorization", authHeader)
+	}
+	res, err := c.cl.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to do HTTP request: %v", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return res, data, nil
+}
+
+func (c *Client) authHeader(repository string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.tokens[repository]; ok {
+		return "Bearer " + t
+	}
+	return ""
+}
+
+// authenticate answers the given WWW-Authenticate challenge and returns the Authorization header
+// value to retry the request with. Bearer tokens are cached per repository.
+func (c *Client) authenticate(ctx context.Context, challenge, repository string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if c.conf.Username == "" {
+			return "", errors.New("registry requires basic auth but no username is configured")
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(c.conf.Username, c.conf.Password)
+		return req.Header.Get("Authorization"), nil
+	case "bearer":
+	default:
+		return "", fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	p := make(map[string]string)
+	for _, m := range challengeRe.FindAllStringSubmatch(params, -1) {
+		p[strings.ToLower(m[1])] = m[2]
+	}
+	if p["realm"] == "" {
+		return "", fmt.Errorf("no realm in auth challenge %q", challenge)
+	}
+	tokenURL, err := url.Parse(p["realm"])
+	if err != nil {
+		return "", fmt.Errorf("url.Parse: %v", err)
+	}
+	q := tokenURL.Query()
+	if p["service"] != "" {
+		q.Set("service", p["service"])
+	}
+	scope := p["scope"]
+	if scope == "" {
+		scope = fmt.Sprintf("repository:%s:pull", repository)
+	}
+	q.Set("scope", scope)
+	tokenURL.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL.String(), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create HTTP request, err: %v", err)
+	}
+	if c.conf.Username != "" {
+		req.SetBasicAuth(c.conf.Username, c.conf.Password)
+	}
+	res, err := c.cl.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to do HTTP request: %v", err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %v", err)
+	}
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return "", fmt.Errorf("token endpoint status code is not in the expected range (%d), response body: %q", res.StatusCode, string(data))
+	}
+	var tr struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.Unmarshal(data, &tr); err != nil {
+		return "", fmt.Errorf("json.Unmarshal: %v", err)
+	}
+	token := tr.Token
+	if token == "" {
+		token = tr.AccessToken
+	}
+	if token == "" {
+		return "", errors.New("token endpoint returned no token")
+	}
+	c.mu.Lock()
+	c.tokens[repository] = token
+	c.mu.Unlock()
+	return "Bearer " + token, nil
+}
@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/edgedelta/updater/core"
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+// newRegistryStub returns a registry which requires a bearer token from its token endpoint and
+// serves the given tags of repository my-org/agent in two pages. Manifest of each tag has
+// testDigest.
+func newRegistryStub(t *testing.T, tags []string) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("scope") != "repository:my-org/agent:pull" || r.URL.Query().Get("service") != "test-registry" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if u, p, ok := r.BasicAuth(); !ok || u != "user" || p != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"token":"registry-token"}`))
+	})
+	mux.HandleFunc("/v2/my-org/agent/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry",scope="repository:my-org/agent:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		half := len(tags) / 2
+		page := tags[:half]
+		if r.URL.Query().Get("last") != "" {
+			page = tags[half:]
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/my-org/agent/tags/list?n=%d&last=%s>; rel="next"`, tagsPageSize, tags[half-1]))
+		}
+		w.Write([]byte(fmt.Sprintf(`{"name":"my-org/agent","tags":["%s"]}`, strings.Join(page, `","`))))
+	})
+	mux.HandleFunc("/v2/my-org/agent/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer registry-token" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test-registry",scope="repository:my-org/agent:pull"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		tag := strings.TrimPrefix(r.URL.Path, "/v2/my-org/agent/manifests/")
+		for _, t := range tags {
+			if t == tag && r.Method == http.MethodHead && strings.Contains(r.Header.Get("Accept"), "application/vnd.oci.image.index.v1+json") {
+				w.Header().Set("Docker-Content-Digest", testDigest)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	srv = httptest.NewServer(mux)
+	return srv
+}
+
+func TestGetLatestApplicableTag(t *testing.T) {
+	srv := newRegistryStub(t, []string{"latest", "v1.4.0", "v1.4.2", "v2.0.0-rc.1", "v1.10.1", "v1.5.0", "nightly-20230101", "v0.9.0"})
+	defer srv.Close()
+	tests := []struct {
+		desc string
+		conf core.RegistryConfig
+		want *core.LatestTagResponse
+	}{
+		{
+			desc: "Highest semantic version",
+			conf: core.RegistryConfig{},
+			want: &core.LatestTagResponse{Tag: "v1.10.1", Image: "my-org/agent", URL: strings.TrimPrefix(srv.URL, "http://") + "/my-org/agent:v1.10.1"},
+		},
+		{
+			desc: "Constraint and image prefix",
+			conf: core.RegistryConfig{Constraint: "~1.4", ImagePrefix: "registry.example.org"},
+			want: &core.LatestTagResponse{Tag: "v1.4.2", Image: "my-org/agent", URL: "registry.example.org/my-org/agent:v1.4.2"},
+		},
+		{
+			desc: "Pre-releases allowed",
+			conf: core.RegistryConfig{AllowPrerelease: true, ImagePrefix: "registry.example.org"},
+			want: &core.LatestTagResponse{Tag: "v2.0.0-rc.1", Image: "my-org/agent", URL: "registry.example.org/my-org/agent:v2.0.0-rc.1"},
+		},
+		{
+			desc: "Tag pattern",
+			conf: core.RegistryConfig{TagPattern: `v1\.5\..*`, ImagePrefix: "registry.example.org"},
+			want: &core.LatestTagResponse{Tag: "v1.5.0", Image: "my-org/agent", URL: "registry.example.org/my-org/agent:v1.5.0"},
+		},
+		{
+			desc: "Tag pattern matching part of the tags",
+			conf: core.RegistryConfig{TagPattern: `1\.4\..*`},
+			want: &core.LatestTagResponse{Image: "my-org/agent"},
+		},
+		{
+			desc: "No applicable tag",
+			conf: core.RegistryConfig{Constraint: ">=3.0"},
+			want: &core.LatestTagResponse{Image: "my-org/agent"},
+		},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			tc.conf.URL = srv.URL
+			tc.conf.Username = "user"
+			tc.conf.Password = "pass"
+			cl, err := NewClient(&tc.conf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := cl.GetLatestApplicableTag(context.Background(), "id", "my-org/agent")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Response mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetLatestApplicableTagUnauthorized(t *testing.T) {
+	srv := newRegistryStub(t, []string{"v1.4.0", "v1.4.2"})
+	defer srv.Close()
+	cl, err := NewClient(&core.RegistryConfig{URL: srv.URL, Username: "user", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.GetLatestApplicableTag(context.Background(), "id", "my-org/agent"); err == nil {
+		t.Fatal("Wanted an error, got nil instead")
+	}
+}
+
+func TestGetDigest(t *testing.T) {
+	srv := newRegistryStub(t, []string{"v1.4.0", "v1.4.2"})
+	defer srv.Close()
+	cl, err := NewClient(&core.RegistryConfig{URL: srv.URL, Username: "user", Password: "pass"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := cl.GetDigest(context.Background(), "my-org/agent", "v1.4.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != testDigest {
+		t.Errorf("Wanted digest %s, got %s instead", testDigest, got)
+	}
+	if _, err := cl.GetDigest(context.Background(), "my-org/agent", "v9.9.9"); err == nil {
+		t.Error("Wanted an error for unknown tag, got nil instead")
+	}
+}
+
+func TestRepository(t *testing.T) {
+	cl, err := NewClient(&core.RegistryConfig{URL: "https://registry.example.org", ImagePrefix: "registry.example.org/team"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		image  string
+		want   string
+		wantOK bool
+	}{
+		{image: "registry.example.org/team/my-org/agent:v1.4.2", want: "my-org/agent", wantOK: true},
+		{image: "registry.example.org/other/agent:v1.4.2"},
+		{image: "gcr.io/team/agent:v1.4.2"},
+	}
+	for _, tc := range tests {
+		got, ok := cl.Repository(tc.image)
+		if got != tc.want || ok != tc.wantOK {
+			t.Errorf("Wanted (%q, %t) for %s, got (%q, %t) instead", tc.want, tc.wantOK, tc.image, got, ok)
+		}
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		desc    string
+		code    int
+		wantErr bool
+	}{
+		{desc: "OK", code: http.StatusOK},
+		{desc: "Authentication required", code: http.StatusUnauthorized},
+		{desc: "Not found", code: http.StatusNotFound, wantErr: true},
+		{desc: "Unavailable", code: http.StatusServiceUnavailable, wantErr: true},
+	}
+	for _, tc := range tests {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/v2/" {
+					w.WriteHeader(http.StatusNotFound)
+					return
+				}
+				w.WriteHeader(tc.code)
+			}))
+			defer srv.Close()
+			cl, err := NewClient(&core.RegistryConfig{URL: srv.URL})
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cl.Ping(context.Background())
+			if tc.wantErr != (err != nil) {
+				t.Errorf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+		})
+	}
+}
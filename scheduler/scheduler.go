@@ -0,0 +1,96 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler runs a job periodically, either at a fixed interval or on a cron schedule. A random
+// delay up to the jitter is added before each scheduled run.
+type Scheduler struct {
+	schedule  cron.Schedule
+	jitter    time.Duration
+	immediate bool
+	// started is the start of the ongoing run and due the time of the next run, both in Unix
+	// nanoseconds and 0 if there is none
+	started atomic.Int64
+	due     atomic.Int64
+}
+
+// NewInterval returns a Scheduler which runs the job right away and then every interval.
+func NewInterval(interval, jitter time.Duration) *Scheduler {
+	return &Scheduler{
+		schedule:  cron.Every(interval),
+		jitter:    jitter,
+		immediate: true,
+	}
+}
+
+// NewCron returns a Scheduler which runs the job on the given standard cron schedule
+// (e.g. "*/10 * * * *").
+func NewCron(spec string, jitter time.Duration) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("cron.ParseStandard: %v", err)
+	}
+	return &Scheduler{schedule: schedule, jitter: jitter}, nil
+}
+
+// Run blocks and runs the job on schedule until ctx is done. Runs never overlap, a run that takes
+// longer than the period delays the next one.
+func (s *Scheduler) Run(ctx context.Context, job func(context.Context)) {
+	defer s.due.Store(0)
+	if s.immediate && ctx.Err() == nil {
+		s.run(ctx, job)
+	}
+	for {
+		now := time.Now()
+		d := s.nextDelay(now)
+		s.due.Store(now.Add(d).UnixNano())
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return
+		case <-t.C:
+			s.run(ctx, job)
+		}
+	}
+}
+
+func (s *Scheduler) run(ctx context.Context, job func(context.Context)) {
+	s.due.Store(0)
+	s.started.Store(time.Now().UnixNano())
+	defer s.started.Store(0)
+	job(ctx)
+}
+
+// Check returns an error if the scheduler is stuck, i.e. if the ongoing run has been running for
+// longer than the threshold or if the next run is overdue by more than the threshold. A scheduler
+// that is not running is never stuck.
+func (s *Scheduler) Check(now time.Time, threshold time.Duration) error {
+	if started := s.started.Load(); started > 0 {
+		if d := now.Sub(time.Unix(0, started)); d > threshold {
+			return fmt.Errorf("run has been running for %s", d.Round(time.Second))
+		}
+	}
+	if due := s.due.Load(); due > 0 {
+		if d := now.Sub(time.Unix(0, due)); d > threshold {
+			return fmt.Errorf("next run is overdue by %s", d.Round(time.Second))
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) nextDelay(now time.Time) time.Duration {
+	d := s.schedule.Next(now).Sub(now)
+	if s.jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(s.jitter)))
+	}
+	return d
+}
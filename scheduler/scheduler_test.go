@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextDelay(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 3, 0, 0, time.UTC)
+	tests := []struct {
+		desc      string
+		scheduler func() (*Scheduler, error)
+		wantMin   time.Duration
+		wantMax   time.Duration
+	}{
+		{
+			desc: "Interval",
+			scheduler: func() (*Scheduler, error) {
+				return NewInterval(5*time.Minute, 0), nil
+			},
+			wantMin: 5 * time.Minute,
+			wantMax: 5 * time.Minute,
+		},
+		{
+			desc: "Interval with jitter",
+			scheduler: func() (*Scheduler, error) {
+				return NewInterval(5*time.Minute, 30*time.Second), nil
+			},
+			wantMin: 5 * time.Minute,
+			wantMax: 5*time.Minute + 30*time.Second,
+		},
+		{
+			desc: "Cron",
+			scheduler: func() (*Scheduler, error) {
+				return NewCron("*/10 * * * *", 0)
+			},
+			wantMin: 7 * time.Minute,
+			wantMax: 7 * time.Minute,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			s, err := tc.scheduler()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 10; i++ {
+				got := s.nextDelay(now)
+				if got < tc.wantMin || got > tc.wantMax {
+					t.Fatalf("Wanted delay in range [%s, %s], got %s instead", tc.wantMin, tc.wantMax, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNewCronInvalid(t *testing.T) {
+	if _, err := NewCron("every ten minutes", 0); err == nil {
+		t.Fatal("Wanted an error, got nil instead")
+	}
+}
+
+func TestRunStopsOnContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runs := 0
+	done := make(chan struct{})
+	go func() {
+		NewInterval(time.Hour, 0).Run(ctx, func(context.Context) {
+			runs++
+			cancel()
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduler did not stop after the context is cancelled")
+	}
+	if runs != 1 {
+		t.Fatalf("Wanted 1 immediate run, got %d instead", runs)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	now := time.Date(2023, 1, 1, 10, 3, 0, 0, time.UTC)
+	tests := []struct {
+		desc    string
+		started time.Time
+		due     time.Time
+		wantErr bool
+	}{
+		{
+			desc: "Not running",
+		},
+		{
+			desc:    "Run within the threshold",
+			started: now.Add(-30 * time.Minute),
+		},
+		{
+			desc:    "Run beyond the threshold",
+			started: now.Add(-2 * time.Hour),
+			wantErr: true,
+		},
+		{
+			desc: "Next run is due",
+			due:  now.Add(10 * time.Minute),
+		},
+		{
+			desc:    "Next run is overdue",
+			due:     now.Add(-2 * time.Hour),
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			s := NewInterval(10*time.Minute, 0)
+			if !tc.started.IsZero() {
+				s.started.Store(tc.started.UnixNano())
+			}
+			if !tc.due.IsZero() {
+				s.due.Store(tc.due.UnixNano())
+			}
+			err := s.Check(now, time.Hour)
+			if tc.wantErr && err == nil {
+				t.Error("Wanted an error, got nil instead")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Wanted no error, got %v instead", err)
+			}
+		})
+	}
+}
@@ -7,17 +7,26 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/edgedelta/updater/api"
 	"github.com/edgedelta/updater/core"
 	"github.com/edgedelta/updater/k8s"
 	"github.com/edgedelta/updater/log"
+	"github.com/edgedelta/updater/metrics"
+	"github.com/edgedelta/updater/registry"
 
 	"github.com/go-yaml/yaml"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/rest"
 )
 
+const (
+	dockerHubRegistryURL = "https://registry-1.docker.io"
+)
+
 var (
+	digestRe                         = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
 	confVarRe                        = regexp.MustCompile(`{{\s*([^{} ]+)\s*}}`)
 	contextualVariableTemplateFormat = `{{ index .Vars "%s" }}`
 )
@@ -25,9 +34,24 @@ var (
 type Updater struct {
 	config *core.UpdaterConfig
 	apiCli core.VersioningServiceClient
+	// tagCli is the source of the latest applicable tags, either apiCli or a registry client
+	tagCli       core.VersioningServiceClient
+	registryClis map[string]*registry.Client
+	verifier     *core.SignatureVerifier
 
 	k8sCliOpts []k8s.NewClientOpt
-	k8sCli     *k8s.Client
+	k8sCli     k8sClient
+}
+
+type k8sClient interface {
+	GetResourceKeyValues(ctx context.Context, path core.K8sResourcePath) ([]k8s.FieldValue, error)
+	SetResourceKeyValues(ctx context.Context, values []k8s.PathValue, dryRun core.DryRunMode, audit *k8s.Audit) ([]k8s.FieldChange, error)
+	WaitForRollout(ctx context.Context, path core.K8sResourcePath, timeout time.Duration, updatedImage string) error
+	RecordEvent(ctx context.Context, path core.K8sResourcePath, eventType, reason, message string) error
+	GetSecret(ctx context.Context, namespace, name string) (string, error)
+	Ping(ctx context.Context) error
+	RunAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) error
+	RunOnceAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) (bool, error)
 }
 
 type NewClientOpt func(*Updater)
@@ -45,7 +69,7 @@ func WithConfig(config *core.UpdaterConfig) NewClientOpt {
 }
 
 func NewUpdater(ctx context.Context, configPath string, opts ...NewClientOpt) (*Updater, error) {
-	u := &Updater{k8sCliOpts: make([]k8s.NewClientOpt, 0)}
+	u := &Updater{k8sCliOpts: make([]k8s.NewClientOpt, 0), registryClis: make(map[string]*registry.Client)}
 	for _, o := range opts {
 		o(u)
 	}
@@ -59,6 +83,14 @@ func NewUpdater(ctx context.Context, configPath string, opts ...NewClientOpt) (*
 			return nil, err
 		}
 	}
+	if conf := u.config.K8s; conf != nil {
+		switch conf.UpdateStrategy {
+		case "", core.UpdateStrategyUpdate, core.UpdateStrategyPatch, core.UpdateStrategyApply:
+		default:
+			return nil, fmt.Errorf("unknown K8s update strategy %q", conf.UpdateStrategy)
+		}
+		u.k8sCliOpts = append(u.k8sCliOpts, k8s.WithUpdateConfig(conf))
+	}
 	cl, err := k8s.NewClient(u.k8sCliOpts...)
 	if err != nil {
 		return nil, err
@@ -70,9 +102,35 @@ func NewUpdater(ctx context.Context, configPath string, opts ...NewClientOpt) (*
 	if err := u.validateEntities(); err != nil {
 		return nil, fmt.Errorf("updater.Updater.validateEntities: %v", err)
 	}
-	u.apiCli = api.NewClient(&u.config.API)
+	if u.config.LeaderElection != nil {
+		if err := k8s.ValidateLeaderElectionConfig(u.config.LeaderElection); err != nil {
+			return nil, fmt.Errorf("invalid leader election config, err: %v", err)
+		}
+	}
+	if u.config.Policy != nil {
+		if err := u.config.Policy.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid image policy, err: %v", err)
+		}
+	}
+	if u.apiCli, err = api.NewClient(&u.config.API); err != nil {
+		return nil, fmt.Errorf("api.NewClient: %v", err)
+	}
+	u.tagCli = u.apiCli
+	if u.config.Registry != nil {
+		if u.tagCli, err = registry.NewClient(u.config.Registry); err != nil {
+			return nil, fmt.Errorf("registry.NewClient: %v", err)
+		}
+	}
+	if sig := u.config.API.Signature; sig != nil {
+		if sig.Endpoint == nil && u.config.Registry != nil {
+			return nil, errors.New("signatures of registry tags can only be verified with a signature endpoint")
+		}
+		if u.verifier, err = core.NewSignatureVerifier(sig); err != nil {
+			return nil, fmt.Errorf("core.NewSignatureVerifier: %v", err)
+		}
+	}
 	if u.config.API.MetadataEndpoint != nil {
-		u.config.Metadata, err = u.apiCli.GetMetadata()
+		u.config.Metadata, err = u.apiCli.GetMetadata(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch metadata, err: %v", err)
 		}
@@ -87,6 +145,38 @@ func (u *Updater) APIClient() *api.Client {
 	return u.apiCli.(*api.Client)
 }
 
+func (u *Updater) RunAsLeader(ctx context.Context, fn func(context.Context)) error {
+	if u.config.LeaderElection == nil {
+		fn(ctx)
+		return nil
+	}
+	return u.k8sCli.RunAsLeader(ctx, u.config.LeaderElection, fn)
+}
+
+func (u *Updater) RunOnceAsLeader(ctx context.Context, fn func(context.Context)) (bool, error) {
+	if u.config.LeaderElection == nil {
+		fn(ctx)
+		return true, nil
+	}
+	return u.k8sCli.RunOnceAsLeader(ctx, u.config.LeaderElection, fn)
+}
+
+func (u *Updater) Ready(ctx context.Context) error {
+	if err := u.k8sCli.Ping(ctx); err != nil {
+		return fmt.Errorf("k8s.Client.Ping: %v", err)
+	}
+	if cl, ok := u.tagCli.(*registry.Client); ok {
+		if err := cl.Ping(ctx); err != nil {
+			return fmt.Errorf("registry.Client.Ping: %v", err)
+		}
+		return nil
+	}
+	if err := u.APIClient().Ping(ctx); err != nil {
+		return fmt.Errorf("api.Client.Ping: %v", err)
+	}
+	return nil
+}
+
 func (u *Updater) LogCustomTags() map[string]string {
 	m := make(map[string]string)
 	if u.config.Log == nil {
@@ -104,6 +194,10 @@ func (u *Updater) LogUploaderEnabled() bool {
 
 // validateEntities function validates the given entities through the rules:
 //   - Each entity ID is unique
+//   - Rollback is only enabled together with waiting for rollouts
+//   - Version policies are valid
+//   - Digest pinning modes are known, version policies need a tag to compare the next tags with
+//   - Value changes have valid paths and known types
 func (u *Updater) validateEntities() error {
 	if len(u.config.Entities) == 0 {
 		return errors.New("no entity is defined, need at least 1")
@@ -114,32 +208,466 @@ func (u *Updater) validateEntities() error {
 			return fmt.Errorf("entity ID %s is used at least twice", e.ID)
 		}
 		ids[e.ID] = true
+		if e.Rollout != nil && e.Rollout.Rollback && !e.Rollout.Wait {
+			return fmt.Errorf("entity with ID %s has rollback enabled without waiting for rollouts", e.ID)
+		}
+		if e.Versions != nil {
+			if err := e.Versions.Validate(); err != nil {
+				return fmt.Errorf("entity with ID %s has invalid version policy, err: %v", e.ID, err)
+			}
+		}
+		switch e.PinDigest {
+		case core.DigestPinningNone, core.DigestPinningDigest, core.DigestPinningTagDigest:
+		default:
+			return fmt.Errorf("entity with ID %s has unknown digest pinning mode %q", e.ID, e.PinDigest)
+		}
+		if e.PinDigest == core.DigestPinningDigest && e.Versions != nil {
+			return fmt.Errorf("entity with ID %s has a version policy, which requires pin_digest %q instead of %q", e.ID, core.DigestPinningTagDigest, e.PinDigest)
+		}
+		for _, ch := range e.Changes {
+			if err := ch.Validate(); err != nil {
+				return fmt.Errorf("entity with ID %s has invalid value change, err: %v", e.ID, err)
+			}
+		}
 	}
 	return nil
 }
 
 func (u *Updater) Run(ctx context.Context) error {
+	start := time.Now()
+	_, err := u.run(ctx, core.DryRunNone)
+	metrics.ObserveRun(time.Since(start), err)
+	return err
+}
+
+func (u *Updater) Plan(ctx context.Context, mode core.DryRunMode) ([]*core.PathChange, error) {
+	return u.run(ctx, mode)
+}
+
+func (u *Updater) run(ctx context.Context, dryRun core.DryRunMode) ([]*core.PathChange, error) {
 	u.logRunningConfig()
 	errors := core.NewErrors()
+	changes := make([]*core.PathChange, 0)
 	for _, entity := range u.config.Entities {
-		res, err := u.apiCli.GetLatestApplicableTag(entity.ID, entity.ImageName)
-		if err != nil {
-			errors.Addf("failed to get latest applicable tag from API for entity with ID %s, err: %v", entity.ID, err)
+		res, results := u.runEntity(ctx, entity, dryRun, errors)
+		changes = append(changes, results...)
+		if dryRun != core.DryRunNone {
 			continue
 		}
-		if res.Tag == "" {
-			log.Info("No applicable tag found for entity with ID %s", entity.ID)
-			continue
+		if succeeded(results) {
+			metrics.EntitySucceeded(entity.ID, time.Now())
+		}
+		if u.config.API.ReportEndpoint != nil {
+			u.report(ctx, entity, res, results)
 		}
-		log.Info("Latest applicable tag from API: %+v", res)
+	}
+	return changes, errors.ErrorOrNil()
+}
+
+func (u *Updater) runEntity(ctx context.Context, entity core.EntityProperties, dryRun core.DryRunMode, errors *core.Errors) (*core.LatestTagResponse, []*core.PathChange) {
+	if dryRun == core.DryRunNone {
+		metrics.EntityAttempted(entity.ID)
+	}
+	res, err := u.tagCli.GetLatestApplicableTag(ctx, entity.ID, entity.ImageName)
+	if err != nil {
+		entityFailed(entity, metrics.ReasonLatestTag, dryRun)
+		errors.Addf("failed to get latest applicable tag for entity with ID %s, err: %v", entity.ID, err)
+		return nil, entityChanges(entity, "", core.ChangeFailed, err)
+	}
+	if res.Tag == "" {
+		log.Info("No applicable tag found for entity with ID %s", entity.ID)
+		return res, entityChanges(entity, "", core.ChangeSkip, nil)
+	}
+	log.Info("Latest applicable tag: %+v", res)
+	if entity.Versions != nil {
+		// The policy is checked against the tag of the image that is written, which the reported tag
+		// must match
+		err := entity.Versions.CheckTag(res.Tag)
+		if tag := core.ImageTag(res.URL); tag != res.Tag {
+			err = fmt.Errorf("tag %q of image %s does not match the reported tag %q", tag, res.URL, res.Tag)
+		}
+		if err != nil {
+			entityFailed(entity, metrics.ReasonVersionPolicy, dryRun)
+			errors.Addf("refused latest applicable tag %s for entity with ID %s by version policy, err: %v", res.Tag, entity.ID, err)
+			return res, entityChanges(entity, res.URL, core.ChangeRejected, err)
+		}
+	}
+	if u.config.Policy != nil {
+		if err := u.config.Policy.Check(res.URL); err != nil {
+			entityFailed(entity, metrics.ReasonImagePolicy, dryRun)
+			errors.Addf("refused latest applicable image for entity with ID %s by image policy, err: %v", entity.ID, err)
+			return res, entityChanges(entity, res.URL, core.ChangeRejected, err)
+		}
+	}
+	if u.verifier != nil {
+		if err := u.verifySignature(ctx, entity, res); err != nil {
+			entityFailed(entity, metrics.ReasonSignature, dryRun)
+			errors.Addf("refused latest applicable tag %s for entity with ID %s, err: %v", res.Tag, entity.ID, err)
+			return res, entityChanges(entity, res.URL, core.ChangeRejected, err)
+		}
+	} else if len(res.Changes) > 0 {
+		// Only the signature makes the value changes of the response as trustworthy as the config
+		err := fmt.Errorf("response has %d value changes, which require signature verification", len(res.Changes))
+		entityFailed(entity, metrics.ReasonSignature, dryRun)
+		errors.Addf("refused latest applicable tag %s for entity with ID %s, err: %v", res.Tag, entity.ID, err)
+		return res, entityChanges(entity, res.URL, core.ChangeRejected, err)
+	}
+	target := res.URL
+	if entity.PinDigest != core.DigestPinningNone {
+		if target, err = u.pinDigest(ctx, res, entity.PinDigest); err != nil {
+			entityFailed(entity, metrics.ReasonDigest, dryRun)
+			errors.Addf("failed to pin latest applicable tag to its digest for entity with ID %s, err: %v", entity.ID, err)
+			return res, entityChanges(entity, res.URL, core.ChangeFailed, err)
+		}
+		log.Info("Pinned latest applicable tag %s to %s for entity with ID %s", res.Tag, target, entity.ID)
+	}
+	if u.config.Policy != nil {
+		if err := u.checkResponseImages(res, target); err != nil {
+			entityFailed(entity, metrics.ReasonImagePolicy, dryRun)
+			errors.Addf("refused value changes of latest applicable tag %s for entity with ID %s by image policy, err: %v", res.Tag, entity.ID, err)
+			return res, entityChanges(entity, res.URL, core.ChangeRejected, err)
+		}
+	}
+	changes := u.updateEntity(ctx, entity, res, target, dryRun, errors)
+	if dryRun == core.DryRunNone && entity.Rollout != nil && entity.Rollout.Wait {
+		u.waitForRollouts(ctx, entity, target, changes, errors)
+	}
+	return res, changes
+}
+
+func (u *Updater) report(ctx context.Context, entity core.EntityProperties, res *core.LatestTagResponse, changes []*core.PathChange) {
+	r := &core.UpdateReport{EntityID: entity.ID, Image: entity.ImageName, Paths: make([]core.PathReport, 0, len(changes))}
+	if res != nil {
+		r.Tag = res.Tag
+	}
+	for _, ch := range changes {
+		r.Paths = append(r.Paths, core.PathReport{
+			Path:                   ch.Path,
+			Old:                    ch.Current,
+			New:                    ch.Target,
+			Status:                 ch.Action,
+			Error:                  ch.Error,
+			RolloutDurationSeconds: ch.RolloutDuration.Seconds(),
+		})
+	}
+	if err := u.APIClient().Report(ctx, r); err != nil {
+		log.Warn("Failed to report the update of entity with ID %s, err: %v", entity.ID, err)
+	}
+}
+
+func (u *Updater) updateEntity(ctx context.Context, entity core.EntityProperties, res *core.LatestTagResponse, target string, dryRun core.DryRunMode, errors *core.Errors) []*core.PathChange {
+	if entity.Versions != nil {
 		for _, path := range entity.K8sPaths {
-			if err := u.k8sCli.SetResourceKeyValue(ctx, path, res.URL); err != nil {
-				errors.Addf("failed to set K8s resource spec key/value for entity with ID %s (path: %s, value: %s), err: %v", entity.ID, path, res.URL, err)
-				continue
+			values, err := u.k8sCli.GetResourceKeyValues(ctx, path)
+			if err != nil {
+				entityFailed(entity, metrics.ReasonK8s, dryRun)
+				errors.Addf("failed to get K8s resource spec value for entity with ID %s (path: %s), err: %v", entity.ID, path, err)
+				return entityChanges(entity, target, core.ChangeFailed, err)
+			}
+			for _, v := range values {
+				if err := entity.Versions.CheckUpdate(core.ImageTag(core.FormatValue(v.Value)), core.ImageTag(target)); err != nil {
+					entityFailed(entity, metrics.ReasonVersionPolicy, dryRun)
+					errors.Addf("refused latest applicable tag %s for entity with ID %s by version policy (path: %s), err: %v", res.Tag, entity.ID, v.Path, err)
+					return entityChanges(entity, target, core.ChangeRejected, err)
+				}
 			}
 		}
 	}
-	return errors.ErrorOrNil()
+	values, err := u.entityValues(entity, res, target)
+	if err != nil {
+		entityFailed(entity, metrics.ReasonValues, dryRun)
+		errors.Addf("failed to evaluate value changes for entity with ID %s, err: %v", entity.ID, err)
+		return entityChanges(entity, target, core.ChangeRejected, err)
+	}
+	fieldChanges, err := u.k8sCli.SetResourceKeyValues(ctx, values, dryRun, &k8s.Audit{EntityID: entity.ID, Tag: res.Tag})
+	if err != nil {
+		entityFailed(entity, metrics.ReasonK8s, dryRun)
+		errors.Addf("failed to set K8s resource spec key/values for entity with ID %s, err: %v", entity.ID, err)
+		return entityChanges(entity, target, core.ChangeFailed, err)
+	}
+	changes := make([]*core.PathChange, 0, len(fieldChanges))
+	failed := false
+	for _, fc := range fieldChanges {
+		change := &core.PathChange{EntityID: entity.ID, Path: fc.Path, Current: core.FormatValue(fc.Old), CurrentValue: fc.Old, Target: core.FormatValue(fc.Value)}
+		switch {
+		case fc.Err != nil:
+			errors.Addf("failed to set K8s resource spec key/value for entity with ID %s (path: %s, value: %s), err: %v", entity.ID, fc.Path, change.Target, fc.Err)
+			change.Action, change.Error = core.ChangeFailed, fc.Err.Error()
+			failed = true
+		case fc.Updated:
+			change.Action = core.ChangeUpdate
+		default:
+			change.Action = core.ChangeUnchanged
+		}
+		changes = append(changes, change)
+	}
+	if failed {
+		entityFailed(entity, metrics.ReasonK8s, dryRun)
+	}
+	return changes
+}
+
+func (u *Updater) entityValues(entity core.EntityProperties, res *core.LatestTagResponse, target string) ([]k8s.PathValue, error) {
+	values := make([]k8s.PathValue, 0, len(entity.K8sPaths)+len(entity.Changes)+len(res.Changes))
+	objects := make(map[string]bool)
+	for _, path := range entity.K8sPaths {
+		values = append(values, k8s.PathValue{Path: path, Value: target})
+		if ri, err := path.Parse(); err == nil {
+			objects[ri.Object()] = true
+		}
+	}
+	for _, ch := range res.Changes {
+		if err := ch.Validate(); err != nil {
+			return nil, err
+		}
+		ri, _ := ch.Path.Parse() // Already validated
+		if !objects[ri.Object()] {
+			return nil, fmt.Errorf("path %s of the response does not target a resource of the entity", ch.Path)
+		}
+	}
+	vars := changeVars(res, target)
+	for _, changes := range [][]core.ValueChange{entity.Changes, res.Changes} {
+		for _, ch := range changes {
+			v, err := ch.Evaluate(vars)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate value of path %s, err: %v", ch.Path, err)
+			}
+			values = append(values, k8s.PathValue{Path: ch.Path, Value: v})
+		}
+	}
+	return values, nil
+}
+
+func changeVars(res *core.LatestTagResponse, target string) map[string]string {
+	return map[string]string{"tag": res.Tag, "image": target, "url": res.URL, "digest": res.Digest}
+}
+
+// checkResponseImages checks the string values of the "image" fields among the response's changes,
+// either set directly or within an object value, e.g. a whole container.
+func (u *Updater) checkResponseImages(res *core.LatestTagResponse, target string) error {
+	vars := changeVars(res, target)
+	for _, ch := range res.Changes {
+		ri, err := ch.Path.Parse()
+		if err != nil {
+			return fmt.Errorf("invalid path %s, err: %v", ch.Path, err)
+		}
+		seg, err := core.ParseKeyPathSegment(ri.KeyPath[len(ri.KeyPath)-1])
+		if err != nil {
+			return fmt.Errorf("invalid path %s, err: %v", ch.Path, err)
+		}
+		v, err := ch.Evaluate(vars)
+		if err != nil {
+			return fmt.Errorf("failed to evaluate value of path %s, err: %v", ch.Path, err)
+		}
+		for _, image := range imageValues(seg.Field, v) {
+			if err := u.config.Policy.Check(image); err != nil {
+				return fmt.Errorf("value of path %s is not allowed, err: %v", ch.Path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func imageValues(field string, v any) []string {
+	images := make([]string, 0)
+	switch t := v.(type) {
+	case string:
+		if field == "image" {
+			images = append(images, t)
+		}
+	case map[string]any:
+		for k, e := range t {
+			images = append(images, imageValues(k, e)...)
+		}
+	case []any:
+		for _, e := range t {
+			images = append(images, imageValues("", e)...)
+		}
+	}
+	return images
+}
+
+func entityChanges(entity core.EntityProperties, target string, action core.ChangeAction, err error) []*core.PathChange {
+	changes := make([]*core.PathChange, 0, len(entity.K8sPaths))
+	for _, path := range entity.K8sPaths {
+		change := &core.PathChange{EntityID: entity.ID, Path: path, Target: target, Action: action}
+		if err != nil {
+			change.Error = err.Error()
+		}
+		changes = append(changes, change)
+	}
+	return changes
+}
+
+func entityFailed(entity core.EntityProperties, reason string, dryRun core.DryRunMode) {
+	if dryRun == core.DryRunNone {
+		metrics.EntityFailed(entity.ID, reason)
+	}
+}
+
+func succeeded(changes []*core.PathChange) bool {
+	for _, ch := range changes {
+		if ch.Action == core.ChangeFailed || ch.Action == core.ChangeRejected {
+			return false
+		}
+	}
+	return true
+}
+
+func (u *Updater) waitForRollouts(ctx context.Context, entity core.EntityProperties, value string, changes []*core.PathChange, errors *core.Errors) {
+	objects := make([]string, 0)
+	objectUpdates := make(map[string][]*core.PathChange)
+	objectUnchanged := make(map[string][]*core.PathChange)
+	for _, ch := range changes {
+		if ch.Action != core.ChangeUpdate && ch.Action != core.ChangeUnchanged {
+			continue
+		}
+		res, err := ch.Path.Parse()
+		if err != nil {
+			errors.Addf("failed to parse K8s resource path for entity with ID %s (path: %s), err: %v", entity.ID, ch.Path, err)
+			continue
+		}
+		if ch.Action == core.ChangeUnchanged {
+			objectUnchanged[res.Object()] = append(objectUnchanged[res.Object()], ch)
+			continue
+		}
+		if _, ok := objectUpdates[res.Object()]; !ok {
+			objects = append(objects, res.Object())
+		}
+		objectUpdates[res.Object()] = append(objectUpdates[res.Object()], ch)
+	}
+	for _, obj := range objects {
+		log.Info("Waiting for rollout of %s for entity with ID %s", obj, entity.ID)
+		start := time.Now()
+		err := u.k8sCli.WaitForRollout(ctx, objectUpdates[obj][0].Path, entity.Rollout.Timeout, value)
+		for _, up := range objectUpdates[obj] {
+			up.RolloutDuration = time.Since(start)
+		}
+		if err == nil {
+			log.Info("Rollout of %s for entity with ID %s is complete", obj, entity.ID)
+			continue
+		}
+		metrics.EntityFailed(entity.ID, metrics.ReasonRollout)
+		errors.Addf("rollout of K8s resource did not succeed for entity with ID %s (resource: %s), err: %v", entity.ID, obj, err)
+		u.recordEvent(ctx, objectUpdates[obj][0].Path, corev1.EventTypeWarning, k8s.EventReasonUpdateFailed, fmt.Sprintf("Rollout did not succeed for entity %s, err: %v", entity.ID, err))
+		for _, up := range objectUpdates[obj] {
+			up.Action, up.Error = core.ChangeFailed, err.Error()
+		}
+		if entity.Rollout.Rollback {
+			u.rollback(ctx, entity, objectUpdates[obj], objectUnchanged[obj], errors)
+		}
+	}
+}
+
+func (u *Updater) recordEvent(ctx context.Context, path core.K8sResourcePath, eventType, reason, message string) {
+	if u.config.K8s != nil && u.config.K8s.DisableAudit {
+		return
+	}
+	if err := u.k8sCli.RecordEvent(ctx, path, eventType, reason, message); err != nil {
+		log.Warn("Failed to record %s event (path: %s), err: %v", reason, path, err)
+	}
+}
+
+// rollback writes the unchanged paths of the object too, server-side applies would otherwise remove
+// the fields left out.
+func (u *Updater) rollback(ctx context.Context, entity core.EntityProperties, updates, unchanged []*core.PathChange, errors *core.Errors) {
+	values := make([]k8s.PathValue, 0, len(updates)+len(unchanged))
+	rollbacks := make([]*core.PathChange, 0, len(updates))
+	for _, up := range updates {
+		if up.CurrentValue == nil {
+			// e.g. an appended slice element, which is left in place
+			errors.Addf("failed to roll back K8s resource for entity with ID %s (path: %s), err: no previous value is known", entity.ID, up.Path)
+			continue
+		}
+		log.Warn("Rolling back resource with path %s to %s for entity with ID %s", up.Path, up.Current, entity.ID)
+		values = append(values, k8s.PathValue{Path: up.Path, Value: up.CurrentValue})
+		rollbacks = append(rollbacks, up)
+	}
+	if len(values) == 0 {
+		return
+	}
+	for _, ch := range unchanged {
+		if ch.CurrentValue != nil {
+			values = append(values, k8s.PathValue{Path: ch.Path, Value: ch.CurrentValue})
+		}
+	}
+	fieldChanges, err := u.k8sCli.SetResourceKeyValues(ctx, values, core.DryRunNone, &k8s.Audit{EntityID: entity.ID, RolledBack: true})
+	for _, fc := range fieldChanges {
+		if err == nil {
+			err = fc.Err
+		}
+	}
+	if err != nil {
+		metrics.EntityFailed(entity.ID, metrics.ReasonRollback)
+		errors.Addf("failed to roll back K8s resource for entity with ID %s (path: %s), err: %v", entity.ID, rollbacks[0].Path, err)
+		return
+	}
+	for _, up := range rollbacks {
+		up.Action = core.ChangeRolledBack
+		log.Info("Rolled back resource with path %s to %s for entity with ID %s", up.Path, up.Current, entity.ID)
+	}
+}
+
+func (u *Updater) verifySignature(ctx context.Context, entity core.EntityProperties, res *core.LatestTagResponse) error {
+	signature := res.Signature
+	if u.config.API.Signature.Endpoint != nil {
+		var err error
+		if signature, err = u.APIClient().GetSignature(ctx, entity.ImageName, res); err != nil {
+			return fmt.Errorf("api.Client.GetSignature: %v", err)
+		}
+	}
+	return u.verifier.Verify(res, signature)
+}
+
+func (u *Updater) pinDigest(ctx context.Context, res *core.LatestTagResponse, mode core.DigestPinning) (string, error) {
+	ref := core.ParseImageReference(res.URL)
+	digest := res.Digest
+	if digest == "" {
+		digest = ref.Digest
+	}
+	if digest == "" {
+		if ref.Tag == "" {
+			return "", fmt.Errorf("image %s has neither a tag nor a digest", res.URL)
+		}
+		cl, repository, err := u.registryClient(ref)
+		if err != nil {
+			return "", err
+		}
+		if digest, err = cl.GetDigest(ctx, repository, ref.Tag); err != nil {
+			return "", fmt.Errorf("registry.Client.GetDigest: %v", err)
+		}
+	}
+	if !digestRe.MatchString(digest) {
+		return "", fmt.Errorf("digest %q is not a sha256 digest", digest)
+	}
+	ref.Digest = digest
+	if mode == core.DigestPinningDigest {
+		ref.Tag = ""
+	}
+	return ref.String(), nil
+}
+
+// registryClient accesses the registries other than the configured one anonymously.
+func (u *Updater) registryClient(ref core.ImageReference) (*registry.Client, string, error) {
+	if cl, ok := u.tagCli.(*registry.Client); ok {
+		if repository, ok := cl.Repository(ref.String()); ok {
+			return cl, repository, nil
+		}
+	}
+	host := ref.RegistryHost()
+	cl, ok := u.registryClis[host]
+	if !ok {
+		url := "https://" + host
+		if host == "docker.io" {
+			url = dockerHubRegistryURL
+		}
+		var err error
+		if cl, err = registry.NewClient(&core.RegistryConfig{URL: url}); err != nil {
+			return nil, "", fmt.Errorf("registry.NewClient: %v", err)
+		}
+		u.registryClis[host] = cl
+	}
+	return cl, ref.RegistryRepository(), nil
 }
 
 func (u *Updater) evaluateConfigVars(ctx context.Context) (err error) {
@@ -147,14 +675,76 @@ func (u *Updater) evaluateConfigVars(ctx context.Context) (err error) {
 		if u.config.Entities[index].ID, err = u.evaluateConfigVar(ctx, entity.ID); err != nil {
 			return
 		}
+		for i, ch := range entity.Changes {
+			if v, ok := ch.Value.(string); ok {
+				if entity.Changes[i].Value, err = u.evaluateConfigVar(ctx, v); err != nil {
+					return
+				}
+			}
+		}
 	}
 	if u.config.API.BaseURL, err = u.evaluateConfigVar(ctx, u.config.API.BaseURL); err != nil {
 		return
 	}
-	if u.config.API.TopLevelAuth != nil {
-		if u.config.API.TopLevelAuth.HeaderValue, err = u.evaluateConfigVar(ctx, u.config.API.TopLevelAuth.HeaderValue); err != nil {
+	if auth := u.config.API.TopLevelAuth; auth != nil {
+		vars := []*string{&auth.HeaderValue}
+		if auth.Bearer != nil {
+			vars = append(vars, &auth.Bearer.Token, &auth.Bearer.TokenFile)
+		}
+		if auth.Basic != nil {
+			vars = append(vars, &auth.Basic.Username, &auth.Basic.Password)
+		}
+		if auth.OAuth2 != nil {
+			vars = append(vars, &auth.OAuth2.TokenURL, &auth.OAuth2.ClientID, &auth.OAuth2.ClientSecret)
+		}
+		if auth.HMAC != nil {
+			vars = append(vars, &auth.HMAC.Secret)
+		}
+		for _, v := range vars {
+			if *v, err = u.evaluateConfigVar(ctx, *v); err != nil {
+				return
+			}
+		}
+	}
+	if tlsConf := u.config.API.TLS; tlsConf != nil {
+		for _, v := range []*string{&tlsConf.CA, &tlsConf.Cert, &tlsConf.Key, &tlsConf.CAFile, &tlsConf.CertFile, &tlsConf.KeyFile} {
+			if *v, err = u.evaluateConfigVar(ctx, *v); err != nil {
+				return
+			}
+		}
+	}
+	if sig := u.config.API.Signature; sig != nil {
+		for _, keys := range [][]string{sig.PublicKeys, sig.PublicKeyFiles} {
+			for i, v := range keys {
+				if keys[i], err = u.evaluateConfigVar(ctx, v); err != nil {
+					return
+				}
+			}
+		}
+		if sig.Endpoint != nil {
+			if sig.Endpoint.Endpoint, err = u.evaluateConfigVar(ctx, sig.Endpoint.Endpoint); err != nil {
+				return
+			}
+			if sig.Endpoint.Params != nil {
+				for k, v := range sig.Endpoint.Params.QueryParams {
+					if sig.Endpoint.Params.QueryParams[k], err = u.evaluateConfigVar(ctx, v); err != nil {
+						return
+					}
+				}
+			}
+		}
+	}
+	if report := u.config.API.ReportEndpoint; report != nil {
+		if report.Endpoint, err = u.evaluateConfigVar(ctx, report.Endpoint); err != nil {
 			return
 		}
+		if report.Params != nil {
+			for k, v := range report.Params.QueryParams {
+				if report.Params.QueryParams[k], err = u.evaluateConfigVar(ctx, v); err != nil {
+					return
+				}
+			}
+		}
 	}
 	if u.config.API.LatestTagEndpoint.Endpoint, err = u.evaluateConfigVar(ctx, u.config.API.LatestTagEndpoint.Endpoint); err != nil {
 		return
@@ -166,12 +756,28 @@ func (u *Updater) evaluateConfigVars(ctx context.Context) (err error) {
 			}
 		}
 	}
-	if u.config.API.LogUpload.PresignedUploadURLEndpoint.Endpoint, err = u.evaluateConfigVar(ctx, u.config.API.LogUpload.PresignedUploadURLEndpoint.Endpoint); err != nil {
-		return
+	if u.config.API.LogUpload != nil {
+		if u.config.API.LogUpload.PresignedUploadURLEndpoint.Endpoint, err = u.evaluateConfigVar(ctx, u.config.API.LogUpload.PresignedUploadURLEndpoint.Endpoint); err != nil {
+			return
+		}
+		if u.config.API.LogUpload.PresignedUploadURLEndpoint.Params != nil {
+			for k, v := range u.config.API.LogUpload.PresignedUploadURLEndpoint.Params.QueryParams {
+				if u.config.API.LogUpload.PresignedUploadURLEndpoint.Params.QueryParams[k], err = u.evaluateConfigVar(ctx, v); err != nil {
+					return
+				}
+			}
+		}
 	}
-	if u.config.API.LogUpload.PresignedUploadURLEndpoint.Params != nil {
-		for k, v := range u.config.API.LogUpload.PresignedUploadURLEndpoint.Params.QueryParams {
-			if u.config.API.LogUpload.PresignedUploadURLEndpoint.Params.QueryParams[k], err = u.evaluateConfigVar(ctx, v); err != nil {
+	if reg := u.config.Registry; reg != nil {
+		for _, v := range []*string{&reg.URL, &reg.Username, &reg.Password} {
+			if *v, err = u.evaluateConfigVar(ctx, *v); err != nil {
+				return
+			}
+		}
+	}
+	if le := u.config.LeaderElection; le != nil {
+		for _, v := range []*string{&le.LeaseName, &le.LeaseNamespace, &le.Identity} {
+			if *v, err = u.evaluateConfigVar(ctx, *v); err != nil {
 				return
 			}
 		}
@@ -252,7 +858,11 @@ func (u *Updater) logRunningConfig() {
 	for _, e := range u.config.Entities {
 		entities = append(entities, fmt.Sprintf("%s:%s", e.ImageName, e.ID))
 	}
-	sb.WriteString(fmt.Sprintf("Updater is running for entities %s with API base URL: %s, latest tag endpoint: %s, log uploader is", strings.Join(entities, ", "), u.config.API.BaseURL, u.config.API.LatestTagEndpoint.Endpoint))
+	if u.config.Registry != nil {
+		sb.WriteString(fmt.Sprintf("Updater is running for entities %s with registry URL: %s, log uploader is", strings.Join(entities, ", "), u.config.Registry.URL))
+	} else {
+		sb.WriteString(fmt.Sprintf("Updater is running for entities %s with API base URL: %s, latest tag endpoint: %s, log uploader is", strings.Join(entities, ", "), u.config.API.BaseURL, u.config.API.LatestTagEndpoint.Endpoint))
+	}
 	if u.LogUploaderEnabled() {
 		sb.WriteString(fmt.Sprintf(" enabled with presigned URL endpoint: %s, encoding: %s, and compression: %s.", u.config.API.LogUpload.PresignedUploadURLEndpoint.Endpoint, u.config.API.LogUpload.Encoding.Type, u.config.API.LogUpload.Compression))
 	} else {
@@ -0,0 +1,322 @@
+package updater
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/edgedelta/updater/api"
+	"github.com/edgedelta/updater/core"
+	"github.com/edgedelta/updater/k8s"
+	"github.com/edgedelta/updater/registry"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const (
+	testPath = core.K8sResourcePath("default:ds/agent:spec.template.spec.containers[0].image")
+)
+
+// fakeK8sClient keeps the values of the resource paths in memory.
+type fakeK8sClient struct {
+	values     map[core.K8sResourcePath]any
+	rolloutErr error
+}
+
+func (c *fakeK8sClient) GetResourceKeyValues(ctx context.Context, path core.K8sResourcePath) ([]k8s.FieldValue, error) {
+	return []k8s.FieldValue{{Path: path, Value: c.values[path]}}, nil
+}
+
+func (c *fakeK8sClient) SetResourceKeyValues(ctx context.Context, values []k8s.PathValue, dryRun core.DryRunMode, audit *k8s.Audit) ([]k8s.FieldChange, error) {
+	changes := make([]k8s.FieldChange, 0, len(values))
+	for _, v := range values {
+		old := c.values[v.Path]
+		updated := !reflect.DeepEqual(old, v.Value)
+		if updated && dryRun == core.DryRunNone {
+			c.values[v.Path] = v.Value
+		}
+		changes = append(changes, k8s.FieldChange{Path: v.Path, Old: old, Value: v.Value, Updated: updated})
+	}
+	return changes, nil
+}
+
+func (c *fakeK8sClient) WaitForRollout(ctx context.Context, path core.K8sResourcePath, timeout time.Duration, updatedImage string) error {
+	return c.rolloutErr
+}
+
+func (c *fakeK8sClient) RecordEvent(ctx context.Context, path core.K8sResourcePath, eventType, reason, message string) error {
+	return nil
+}
+
+func (c *fakeK8sClient) GetSecret(ctx context.Context, namespace, name string) (string, error) {
+	return "", errors.New("not found")
+}
+
+func (c *fakeK8sClient) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (c *fakeK8sClient) RunAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) error {
+	fn(ctx)
+	return nil
+}
+
+func (c *fakeK8sClient) RunOnceAsLeader(ctx context.Context, conf *core.LeaderElectionConfig, fn func(context.Context)) (bool, error) {
+	fn(ctx)
+	return true, nil
+}
+
+// fakeTagClient returns the same latest tag response for every entity.
+type fakeTagClient struct {
+	res *core.LatestTagResponse
+}
+
+func (c *fakeTagClient) GetLatestApplicableTag(ctx context.Context, entityID, entityName string) (*core.LatestTagResponse, error) {
+	return c.res, nil
+}
+
+func (c *fakeTagClient) GetPresignedLogUploadURL(ctx context.Context, logSize int) (string, error) {
+	return "", errors.New("not implemented")
+}
+
+func (c *fakeTagClient) UploadLogs(ctx context.Context, lines []any) error {
+	return errors.New("not implemented")
+}
+
+func (c *fakeTagClient) GetMetadata(ctx context.Context) (map[string]string, error) {
+	return nil, errors.New("not implemented")
+}
+
+func newTestUpdater(config *core.UpdaterConfig, k8sCli *fakeK8sClient, res *core.LatestTagResponse) *Updater {
+	tagCli := &fakeTagClient{res: res}
+	return &Updater{
+		config:       config,
+		apiCli:       tagCli,
+		tagCli:       tagCli,
+		registryClis: make(map[string]*registry.Client),
+		k8sCli:       k8sCli,
+	}
+}
+
+func TestValidateEntities(t *testing.T) {
+	tests := []struct {
+		desc    string
+		entity  core.EntityProperties
+		wantErr bool
+	}{
+		{
+			desc:   "Version policy with tag and digest pinning",
+			entity: core.EntityProperties{ID: "111", K8sPaths: []core.K8sResourcePath{testPath}, Versions: &core.VersionPolicy{}, PinDigest: core.DigestPinningTagDigest},
+		},
+		{
+			desc:    "Version policy with digest pinning",
+			entity:  core.EntityProperties{ID: "111", K8sPaths: []core.K8sResourcePath{testPath}, Versions: &core.VersionPolicy{}, PinDigest: core.DigestPinningDigest},
+			wantErr: true,
+		},
+		{
+			desc:    "Rollback without waiting for rollouts",
+			entity:  core.EntityProperties{ID: "111", K8sPaths: []core.K8sResourcePath{testPath}, Rollout: &core.RolloutConfig{Rollback: true}},
+			wantErr: true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			u := &Updater{config: &core.UpdaterConfig{Entities: []core.EntityProperties{tc.entity}}}
+			err := u.validateEntities()
+			if tc.wantErr && err == nil {
+				t.Error("Wanted an error, got nil instead")
+			}
+			if !tc.wantErr && err != nil {
+				t.Errorf("Wanted no error, got %v instead", err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigConf := &core.SignatureConfig{PublicKeys: []string{string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))}}
+	sidecarPath := core.K8sResourcePath("default:ds/agent:spec.template.spec.containers[1].image")
+	containerPath := core.K8sResourcePath("default:ds/agent:spec.template.spec.containers[1]")
+	current := map[core.K8sResourcePath]any{testPath: "gcr.io/edgedelta/agent:v0.1.46", sidecarPath: "gcr.io/edgedelta/sidecar:v0.1.46"}
+	tests := []struct {
+		desc       string
+		versions   *core.VersionPolicy
+		rollout    *core.RolloutConfig
+		res        *core.LatestTagResponse
+		signed     bool
+		rolloutErr error
+		wantErr    bool
+		wantValues map[core.K8sResourcePath]any
+		wantPaths  []core.PathReport
+	}{
+		{
+			desc:       "Updated",
+			res:        &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47"},
+			wantValues: map[core.K8sResourcePath]any{testPath: "gcr.io/edgedelta/agent:v0.1.47", sidecarPath: "gcr.io/edgedelta/sidecar:v0.1.46"},
+			wantPaths:  []core.PathReport{{Path: testPath, Old: "gcr.io/edgedelta/agent:v0.1.46", New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeUpdate}},
+		},
+		{
+			desc:       "Tag within the version policy",
+			versions:   &core.VersionPolicy{Constraint: "~0.1"},
+			res:        &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47"},
+			wantValues: map[core.K8sResourcePath]any{testPath: "gcr.io/edgedelta/agent:v0.1.47", sidecarPath: "gcr.io/edgedelta/sidecar:v0.1.46"},
+			wantPaths:  []core.PathReport{{Path: testPath, Old: "gcr.io/edgedelta/agent:v0.1.46", New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeUpdate}},
+		},
+		{
+			desc:       "Tag outside of the version policy",
+			versions:   &core.VersionPolicy{Constraint: "~0.1"},
+			res:        &core.LatestTagResponse{Tag: "v2.0.0", URL: "gcr.io/edgedelta/agent:v2.0.0"},
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v2.0.0", Status: core.ChangeRejected}},
+		},
+		{
+			desc:       "Downgrade",
+			versions:   &core.VersionPolicy{Constraint: "~0.1"},
+			res:        &core.LatestTagResponse{Tag: "v0.1.45", URL: "gcr.io/edgedelta/agent:v0.1.45"},
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v0.1.45", Status: core.ChangeRejected}},
+		},
+		{
+			desc:       "Tag of the image outside of the version policy",
+			versions:   &core.VersionPolicy{Constraint: "~0.1"},
+			res:        &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v2.0.0"},
+			signed:     true,
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v2.0.0", Status: core.ChangeRejected}},
+		},
+		{
+			desc:       "Failed rollout rolled back",
+			rollout:    &core.RolloutConfig{Wait: true, Rollback: true},
+			res:        &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47"},
+			rolloutErr: errors.New("container agent is in CrashLoopBackOff"),
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, Old: "gcr.io/edgedelta/agent:v0.1.46", New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeRolledBack}},
+		},
+		{
+			desc: "Signed changes with allowed image",
+			res: &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47", Changes: []core.ValueChange{
+				{Path: sidecarPath, Value: "gcr.io/edgedelta/sidecar:{{ .ctx.tag }}"},
+			}},
+			signed:     true,
+			wantValues: map[core.K8sResourcePath]any{testPath: "gcr.io/edgedelta/agent:v0.1.47", sidecarPath: "gcr.io/edgedelta/sidecar:v0.1.47"},
+			wantPaths: []core.PathReport{
+				{Path: testPath, Old: "gcr.io/edgedelta/agent:v0.1.46", New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeUpdate},
+				{Path: sidecarPath, Old: "gcr.io/edgedelta/sidecar:v0.1.46", New: "gcr.io/edgedelta/sidecar:v0.1.47", Status: core.ChangeUpdate},
+			},
+		},
+		{
+			desc: "Unsigned changes",
+			res: &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47", Changes: []core.ValueChange{
+				{Path: sidecarPath, Value: "gcr.io/edgedelta/sidecar:v0.1.47"},
+			}},
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeRejected}},
+		},
+		{
+			desc: "Signed changes with disallowed image",
+			res: &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47", Changes: []core.ValueChange{
+				{Path: sidecarPath, Value: "evil.io/miner:latest"},
+			}},
+			signed:     true,
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeRejected}},
+		},
+		{
+			desc: "Signed changes with disallowed image within an object",
+			res: &core.LatestTagResponse{Tag: "v0.1.47", URL: "gcr.io/edgedelta/agent:v0.1.47", Changes: []core.ValueChange{
+				{Path: containerPath, Value: map[string]any{"name": "sidecar", "image": "evil.io/miner:latest"}},
+			}},
+			signed:     true,
+			wantErr:    true,
+			wantValues: current,
+			wantPaths:  []core.PathReport{{Path: testPath, New: "gcr.io/edgedelta/agent:v0.1.47", Status: core.ChangeRejected}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.desc, func(t *testing.T) {
+			reports := make([]core.UpdateReport, 0)
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var report core.UpdateReport
+				if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				reports = append(reports, report)
+			}))
+			defer srv.Close()
+			apiConf := core.APIConfig{BaseURL: srv.URL, ReportEndpoint: &core.EndpointConfig{Endpoint: "/report"}}
+			if tc.signed {
+				apiConf.Signature = sigConf
+			}
+			apiCli, err := api.NewClient(&apiConf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			policy := &core.ImagePolicy{Registries: []string{"gcr.io"}, Repositories: []string{"edgedelta/*"}}
+			if err := policy.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			res := *tc.res
+			res.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(priv, core.SignaturePayload(&res)))
+			values := make(map[core.K8sResourcePath]any)
+			for k, v := range current {
+				values[k] = v
+			}
+			k8sCli := &fakeK8sClient{values: values, rolloutErr: tc.rolloutErr}
+			u := newTestUpdater(&core.UpdaterConfig{
+				Entities: []core.EntityProperties{{ID: "111", ImageName: "agent", K8sPaths: []core.K8sResourcePath{testPath}, Versions: tc.versions, Rollout: tc.rollout}},
+				API:      apiConf,
+				Policy:   policy,
+			}, k8sCli, &res)
+			u.apiCli = apiCli
+			if tc.signed {
+				if u.verifier, err = core.NewSignatureVerifier(sigConf); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, err = u.run(context.Background(), core.DryRunNone)
+			if tc.wantErr != (err != nil) {
+				t.Errorf("Wanted error: %t, got %v instead", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.wantValues, k8sCli.values); diff != "" {
+				t.Errorf("Values mismatch (-want +got):\n%s", diff)
+			}
+			if len(reports) != 1 {
+				t.Fatalf("Wanted 1 report, got %d instead", len(reports))
+			}
+			want := core.UpdateReport{EntityID: "111", Image: "agent", Tag: res.Tag, Paths: tc.wantPaths}
+			// Errors are checked through the run's error, rollout durations depend on the timing
+			for i := range reports[0].Paths {
+				reports[0].Paths[i].Error, reports[0].Paths[i].RolloutDurationSeconds = "", 0
+			}
+			if diff := cmp.Diff(want, reports[0]); diff != "" {
+				t.Errorf("Report mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}